@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// accessSuffix is appended to a cache key to form its access marker key.
+// The marker is an empty object rewritten on every restore, so its LastModified
+// is the cache's last access time. Markers share the cache's key prefix, which means
+// a single ListObjectsV2 pass returns both caches and their access times.
+const accessSuffix = ".access"
+
+// accessKey returns the access marker key for the given cache key.
+func accessKey(key string) string {
+	return key + accessSuffix
+}
+
+// isAccessKey reports whether the object key is an access marker rather than a cache archive.
+func isAccessKey(key string) bool {
+	return strings.HasSuffix(key, accessSuffix)
+}
+
+// RecordAccess marks the cache as used now by rewriting its access marker.
+func RecordAccess(key string, bucket string) error {
+	session, err := getS3Client(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	_, err = session.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(accessKey(key)),
+		Body:   strings.NewReader(""),
+	})
+	return err
+}
+
+// lastUsed returns the time used to rank caches for least-recently-used eviction:
+// the most recent of the last restore and the last write.
+func (c CacheObject) lastUsed() time.Time {
+	if c.LastAccessed.After(c.LastModified) {
+		return c.LastAccessed
+	}
+	return c.LastModified
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// listScopedCaches returns the caches list and prune operate on: every cache under the
+// configured prefix, and with branch scope also those under the prefix in any ref namespace.
+func listScopedCaches(action Action) ([]CacheObject, error) {
+	if action.Scope.Mode != ScopeBranch {
+		return ListCaches(action.Prefix, action.Bucket)
+	}
+	// Ref namespaces sit between the key prefix and the key, so no single listing prefix covers them
+	all, err := ListCaches(action.KeyPrefix, action.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(action.Prefix, action.KeyPrefix)
+	var caches []CacheObject
+	for _, c := range all {
+		if matchesScopedPrefix(strings.TrimPrefix(c.Key, action.KeyPrefix), prefix) {
+			caches = append(caches, c)
+		}
+	}
+	return caches, nil
+}
+
+// runList prints every cache stored under the configured prefix, newest first.
+func runList(action Action) error {
+	caches, err := listScopedCaches(action)
+	if err != nil {
+		return fmt.Errorf("failed to list caches: %w", err)
+	}
+
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].LastModified.After(caches[j].LastModified)
+	})
+
+	var total int64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSIZE\tAGE\tLAST USED\tSTORAGE CLASS\tCOMPRESSION")
+	for _, c := range caches {
+		total += c.Size
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			c.Key, getReadableBytes(c.Size), getReadableAge(time.Since(c.LastModified)),
+			getReadableAge(time.Since(c.lastUsed())), storageClassOrDefault(c.StorageClass), c.Compression)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	slog.Info("listed caches", "prefix", action.Prefix, "count", len(caches), "total_size", getReadableBytes(total))
+	return nil
+}
+
+// runInspect prints a cache's object metadata followed by its file listing.
+// The archive is streamed and only tar headers are read; nothing is written to disk.
+func runInspect(action Action) error {
+	props, err := ObjectProperties(action.Key, action.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to read cache metadata: %w", err)
+	}
+
+	compression := compressionFromKey(action.Key)
+	if compression == "" {
+		compression = action.Compression
+	}
+
+	out := os.Stdout
+	fmt.Fprintf(out, "Key:           %s\n", action.Key)
+	fmt.Fprintf(out, "Size:          %s\n", getReadableBytes(aws.ToInt64(props.ContentLength)))
+	if props.LastModified != nil {
+		fmt.Fprintf(out, "Last modified: %s (%s ago)\n", props.LastModified.UTC().Format(time.RFC3339), getReadableAge(time.Since(*props.LastModified)))
+	}
+	fmt.Fprintf(out, "Storage class: %s\n", storageClassOrDefault(string(props.StorageClass)))
+	fmt.Fprintf(out, "Compression:   %s\n", compression)
+	if props.ETag != nil {
+		fmt.Fprintf(out, "ETag:          %s\n", *props.ETag)
+	}
+	for _, k := range sortedKeys(props.Metadata) {
+		fmt.Fprintf(out, "Metadata:      %s=%s\n", k, props.Metadata[k])
+	}
+	fmt.Fprintln(out)
+
+	body, err := OpenObject(action.Key, action.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to open cache: %w", err)
+	}
+	defer body.Close()
+
+	var fileCount int
+	var totalSize int64
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODE\tSIZE\tMODIFIED\tPATH")
+	// Inspecting restores nothing, so plaintext caches stay readable with a keyring configured
+	ac := ArchiveConfig{Compression: compression, Keyring: action.Keyring, AllowUnencrypted: true}
+	manifest, err := ReadArchiveHeaders(body, ac, func(h *tar.Header) error {
+		name := h.Name
+		if h.Typeflag == tar.TypeSymlink {
+			name += " -> " + h.Linkname
+		}
+		if h.Typeflag == tar.TypeReg {
+			fileCount++
+			totalSize += h.Size
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			h.FileInfo().Mode(), getReadableBytes(h.Size), h.ModTime.UTC().Format(time.RFC3339), name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read cache archive: %w", err)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\n%d files, %s uncompressed\n", fileCount, getReadableBytes(totalSize))
+	if manifest != nil {
+		fmt.Fprintf(out, "Created by %s at %s from patterns: %s\n",
+			manifest.ToolVersion, manifest.CreatedAt.Format(time.RFC3339), strings.Join(manifest.Patterns, ", "))
+	}
+	return nil
+}
+
+// storageClassOrDefault maps the empty storage class S3 reports for STANDARD objects to its name.
+func storageClassOrDefault(class string) string {
+	if class == "" {
+		return "STANDARD"
+	}
+	return class
+}
+
+// getReadableAge formats a duration as a coarse age such as "3d4h" or "12m5s".
+func getReadableAge(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	days := int(d / (24 * time.Hour))
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, int((d%(24*time.Hour))/time.Hour))
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetReadableAge(t *testing.T) {
+	tests := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{-time.Second, "0s"},
+		{90*time.Second + 500*time.Millisecond, "1m30s"},
+		{3*time.Hour + 5*time.Minute, "3h5m0s"},
+		{50 * time.Hour, "2d2h"},
+	}
+	for _, tt := range tests {
+		result := getReadableAge(tt.d)
+		if result != tt.expected {
+			t.Errorf("getReadableAge(%v) = %q, want %q", tt.d, result, tt.expected)
+		}
+	}
+}
+
+func TestStorageClassOrDefault(t *testing.T) {
+	if got := storageClassOrDefault(""); got != "STANDARD" {
+		t.Errorf("storageClassOrDefault(\"\") = %q, want STANDARD", got)
+	}
+	if got := storageClassOrDefault("ONEZONE_IA"); got != "ONEZONE_IA" {
+		t.Errorf("storageClassOrDefault(ONEZONE_IA) = %q", got)
+	}
+}
@@ -2,33 +2,149 @@ package main
 
 import (
 	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	zstd "github.com/klauspost/compress/zstd"
 )
 
+// ArchiveConfig holds archive format settings shared by creation and extraction.
+// Zero values mean "use defaults".
+type ArchiveConfig struct {
+	Compression        string       // "zstd" or "none"
+	CompressionLevel   int          // zstd level (1-19), 0 = default
+	Keyring            *Keyring     // client-side encryption keys, nil = no encryption
+	AllowUnencrypted   bool         // with a keyring, still read archives that are not encrypted
+	Rewrites           PathRewrites // applied to stored names when archiving, reversed when extracting
+	RestoreRoot        string       // directory to extract into, "" = current directory
+	CrossOS            bool         // archive portable names and modes for restoring on any OS
+	ReadConcurrency    int          // files read ahead in parallel while archiving, 0 = defaultConcurrency
+	ExtractConcurrency int          // files written in parallel while extracting, 0 = defaultConcurrency
+	Reproducible       bool         // byte-identical archives for identical inputs
+	SourceDateEpoch    time.Time    // reproducible archives clamp timestamps to this, zero = Unix epoch
+}
+
 // zstdEncoderOptions returns zstd encoder options based on compression level.
-// Level 0 means use the default.
+// Level 0 means use the default; other levels follow the zstd command line tool (1-19)
+// and are mapped onto the closest encoder speed.
 func zstdEncoderOptions(level int) []zstd.EOption {
 	opts := []zstd.EOption{zstd.WithEncoderConcurrency(runtime.NumCPU())}
 	if level > 0 {
-		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
 	}
 	return opts
 }
 
+// newArchiveWriter sets up the writer chain: tar -> (optional zstd) -> (optional encryption) -> w.
+// The returned close function flushes and closes every layer in order; it does not close w.
+func newArchiveWriter(w io.Writer, ac ArchiveConfig) (*tar.Writer, func() error, error) {
+	var ew io.WriteCloser
+	if ac.Keyring != nil {
+		var err error
+		ew, err = newEncryptWriter(w, ac.Keyring.Primary())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create encrypt writer: %w", err)
+		}
+		w = ew
+	}
+
+	var zw *zstd.Encoder
+	if ac.Compression == CompressionZstd {
+		var err error
+		zw, err = zstd.NewWriter(w, zstdEncoderOptions(ac.CompressionLevel)...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		w = zw
+	}
+
+	tw := tar.NewWriter(w)
+	closeAll := func() error {
+		// Close tar writer first
+		if err := tw.Close(); err != nil {
+			return fmt.Errorf("failed to close tar writer: %w", err)
+		}
+		// Close zstd writer to flush remaining data (if used)
+		if zw != nil {
+			if err := zw.Close(); err != nil {
+				return fmt.Errorf("failed to close zstd writer: %w", err)
+			}
+		}
+		// Seal the final encrypted chunk (if used)
+		if ew != nil {
+			if err := ew.Close(); err != nil {
+				return fmt.Errorf("failed to close encrypt writer: %w", err)
+			}
+		}
+		return nil
+	}
+	return tw, closeAll, nil
+}
+
+// newArchiveReader mirrors newArchiveWriter: r -> (optional decryption) -> (optional zstd) -> tar.
+// Encrypted archives are detected from their header. With a keyring configured, plain archives
+// are refused with ErrUnencryptedCache unless ac.AllowUnencrypted is set.
+// The returned close function releases decoder resources.
+func newArchiveReader(r io.Reader, ac ArchiveConfig) (*tar.Reader, func(), error) {
+	plain, closeFn, err := newPlainReader(r, ac)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tar.NewReader(plain), closeFn, nil
+}
+
+// newPlainReader returns the decrypted and decompressed tar stream of an archive.
+func newPlainReader(r io.Reader, ac ArchiveConfig) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	r = br
+	if isEncrypted(br) {
+		dr, err := newDecryptReader(br, ac.Keyring)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = dr
+	} else if ac.Keyring != nil && !ac.AllowUnencrypted {
+		return nil, nil, ErrUnencryptedCache
+	}
+
+	if ac.Compression == CompressionZstd {
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(runtime.NumCPU()))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
 // Zip creates an archive from the given artifact glob patterns.
 // compression controls the format: "zstd" produces .tar.zst, "none" produces a plain .tar.
 // compressionLevel is only used for zstd (1-19, 0 = default).
 func Zip(filename string, artifacts []string, compression string, compressionLevel int) error {
+	return ZipArchive(filename, artifacts, ArchiveConfig{Compression: compression, CompressionLevel: compressionLevel})
+}
+
+// ZipArchive creates an archive file from the given artifact glob patterns using ac.
+func ZipArchive(filename string, artifacts []string, ac ArchiveConfig) error {
 	start := time.Now()
-	slog.Info("starting to zip", "filename", filename, "compression", compression)
+	slog.Info("starting to zip", "filename", filename, "compression", ac.Compression)
+
+	manifest, err := BuildArchiveManifest(artifacts, ac)
+	if err != nil {
+		return err
+	}
 
 	// Create output file first - stream directly to it instead of buffering in memory
 	outFile, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
@@ -37,35 +153,17 @@ func Zip(filename string, artifacts []string, compression string, compressionLev
 	}
 	defer outFile.Close()
 
-	// Set up the writer chain: tar -> (optional zstd) -> file
-	var tw *tar.Writer
-	var zw *zstd.Encoder
-
-	if compression == CompressionZstd {
-		zw, err = zstd.NewWriter(outFile, zstdEncoderOptions(compressionLevel)...)
-		if err != nil {
-			return fmt.Errorf("failed to create zstd writer: %w", err)
-		}
-		tw = tar.NewWriter(zw)
-	} else {
-		tw = tar.NewWriter(outFile)
-	}
-
-	fileCount, err := archiveArtifacts(tw, artifacts)
+	tw, closeWriter, err := newArchiveWriter(outFile, ac)
 	if err != nil {
 		return err
 	}
 
-	// Close tar writer first
-	if err := tw.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+	if err := archiveArtifacts(tw, manifest, ac); err != nil {
+		return err
 	}
 
-	// Close zstd writer to flush remaining data (if used)
-	if zw != nil {
-		if err := zw.Close(); err != nil {
-			return fmt.Errorf("failed to close zstd writer: %w", err)
-		}
+	if err := closeWriter(); err != nil {
+		return err
 	}
 
 	// Get final file size
@@ -75,62 +173,74 @@ func Zip(filename string, artifacts []string, compression string, compressionLev
 	}
 
 	elapsed := time.Since(start)
-	slog.Info("successfully zipped", "size", getReadableBytes(fileInfo.Size()), "files", fileCount, "duration", elapsed)
+	slog.Info("successfully zipped", "size", getReadableBytes(fileInfo.Size()), "files", manifest.FileCount, "duration", elapsed)
 	return nil
 }
 
-// archiveArtifacts walks the given glob patterns and writes matching files into the tar writer.
-// Returns the number of files added.
-func archiveArtifacts(tw *tar.Writer, artifacts []string) (int, error) {
-	var fileCount int
-	for _, pattern := range artifacts {
-		matches, err := filepath.Glob(pattern)
+// archiveArtifacts writes the manifest as the first tar entry, followed by every path it lists.
+// Files are opened and read ahead by up to ac.ReadConcurrency goroutines but always written in manifest order.
+// The manifest comes first so readers can inspect an archive from its first bytes, which
+// means every file is hashed before any is archived. File contents are then re-hashed while
+// copying so that a file modified after the manifest was built cannot silently end up in the
+// archive with a stale hash. Each file is therefore read twice; the second read usually hits
+// the page cache, but the hashing CPU time is paid twice.
+func archiveArtifacts(tw *tar.Writer, manifest *Manifest, ac ArchiveConfig) error {
+	if err := writeManifestEntry(tw, manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	copied := newProgress("archive", "", manifest.TotalSize)
+	files := newReadAhead(manifest.Files, ac.ReadConcurrency)
+	defer files.close()
+
+	for _, entry := range manifest.Files {
+		header, err := tar.FileInfoHeader(entry.info, entry.Linkname)
 		if err != nil {
-			return fileCount, err
+			return err
 		}
-		slog.Debug("processing pattern", "pattern", pattern, "matches", len(matches))
-		if len(matches) == 0 {
-			slog.Warn("no matches for pattern", "pattern", pattern)
+
+		// must provide real name
+		// (see https://golang.org/src/archive/tar/common.go?#L626)
+		header.Name = entry.Path
+		if manifest.CrossOS {
+			header.Mode = entry.Mode
+		}
+		if manifest.Reproducible {
+			normalizeHeader(header, entry)
 		}
-		for _, match := range matches {
-			walkErr := filepath.Walk(match, func(file string, fi os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
 
-				header, err := tar.FileInfoHeader(fi, file)
-				if err != nil {
-					return err
-				}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.Type != EntryTypeFile {
+			continue
+		}
+		if err := copyFileToArchive(&progressWriter{w: tw, p: copied}, files.next(), entry); err != nil {
+			return err
+		}
+		slog.Debug("added file to archive", "file", entry.source, "size", entry.Size)
+	}
+	return nil
+}
 
-				// must provide real name
-				// (see https://golang.org/src/archive/tar/common.go?#L626)
-				header.Name = filepath.ToSlash(file)
+// copyFileToArchive copies a single read-ahead file into the tar writer, verifying its manifest hash.
+func copyFileToArchive(tw io.Writer, f *prefetchedFile, entry ManifestEntry) error {
+	if f.err != nil {
+		return f.err
+	}
+	defer f.Close()
 
-				if err := tw.WriteHeader(header); err != nil {
-					return err
-				}
-				if !fi.IsDir() {
-					data, err := os.Open(file)
-					if err != nil {
-						return err
-					}
-					defer data.Close()
-
-					if _, err := io.Copy(tw, data); err != nil {
-						return err
-					}
-					fileCount++
-					slog.Debug("added file to archive", "file", file, "size", fi.Size())
-				}
-				return nil
-			})
-			if walkErr != nil {
-				return fileCount, walkErr
-			}
-		}
+	h := sha256.New()
+	_, err := io.Copy(io.MultiWriter(tw, h), f.reader())
+	if errors.Is(err, tar.ErrWriteTooLong) {
+		return fmt.Errorf("file %s changed while archiving", entry.source)
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != entry.SHA256 {
+		return fmt.Errorf("file %s changed while archiving", entry.source)
 	}
-	return fileCount, nil
+	return nil
 }
 
 // ZipStream creates a streaming archive and returns an io.ReadCloser.
@@ -139,6 +249,20 @@ func archiveArtifacts(tw *tar.Writer, artifacts []string) (int, error) {
 // compression controls the format: "zstd" produces tar.zst, "none" produces plain tar.
 // The caller MUST call Close() on the returned reader when done.
 func ZipStream(artifacts []string, compression string, compressionLevel int) (io.ReadCloser, <-chan error) {
+	return zipStream(context.Background(), artifacts, nil, ArchiveConfig{Compression: compression, CompressionLevel: compressionLevel})
+}
+
+// ZipManifestStream is like ZipStream but archives the paths listed in an already
+// built manifest. ac.Compression should match the manifest's compression, and the
+// manifest should come from BuildArchiveManifest so its paths are already rewritten.
+// The time spent archiving is recorded as a compress phase under the span in ctx.
+func ZipManifestStream(ctx context.Context, manifest *Manifest, ac ArchiveConfig) (io.ReadCloser, <-chan error) {
+	return zipStream(ctx, manifest.Patterns, manifest, ac)
+}
+
+// zipStream backs ZipStream and ZipManifestStream. A nil manifest is built from
+// the artifact patterns inside the archiving goroutine.
+func zipStream(ctx context.Context, artifacts []string, manifest *Manifest, ac ArchiveConfig) (io.ReadCloser, <-chan error) {
 	pr, pw := io.Pipe()
 	errChan := make(chan error, 1)
 
@@ -146,42 +270,42 @@ func ZipStream(artifacts []string, compression string, compressionLevel int) (io
 		defer pw.Close()
 		defer close(errChan)
 
-		var tw *tar.Writer
-		var zw *zstd.Encoder
+		fail := func(err error) {
+			pw.CloseWithError(err)
+			errChan <- err
+		}
 
-		if compression == CompressionZstd {
+		if manifest == nil {
 			var err error
-			zw, err = zstd.NewWriter(pw, zstdEncoderOptions(compressionLevel)...)
+			manifest, err = BuildArchiveManifest(artifacts, ac)
 			if err != nil {
-				errChan <- fmt.Errorf("failed to create zstd writer: %w", err)
+				fail(err)
 				return
 			}
-			tw = tar.NewWriter(zw)
-		} else {
-			tw = tar.NewWriter(pw)
 		}
 
-		fileCount, err := archiveArtifacts(tw, artifacts)
+		// Time blocked writing to the pipe is the upload falling behind, not archiving
+		start := time.Now()
+		out := &timingWriter{w: pw}
+		tw, closeWriter, err := newArchiveWriter(out, ac)
 		if err != nil {
-			errChan <- err
+			fail(err)
 			return
 		}
 
-		// Close tar writer first
-		if err := tw.Close(); err != nil {
-			errChan <- fmt.Errorf("failed to close tar writer: %w", err)
+		if err := archiveArtifacts(tw, manifest, ac); err != nil {
+			fail(err)
 			return
 		}
 
-		// Close zstd writer to flush remaining data (if used)
-		if zw != nil {
-			if err := zw.Close(); err != nil {
-				errChan <- fmt.Errorf("failed to close zstd writer: %w", err)
-				return
-			}
+		if err := closeWriter(); err != nil {
+			fail(err)
+			return
 		}
+		recordPhase(ctx, SpanCompress, start, time.Since(start)-out.elapsed,
+			"files", manifest.FileCount, "bytes_in", manifest.TotalSize, "bytes_out", out.n)
 
-		slog.Debug("streaming archive completed", "files", fileCount, "compression", compression)
+		slog.Debug("streaming archive completed", "files", manifest.FileCount, "compression", ac.Compression)
 	}()
 
 	return pr, errChan
@@ -190,28 +314,63 @@ func ZipStream(artifacts []string, compression string, compressionLevel int) (io
 // Unzip extracts an archive created by Zip.
 // compression controls the expected format: "zstd" reads tar.zst, "none" reads plain tar.
 func Unzip(filename string, compression string) error {
+	_, err := UnzipArchive(filename, ArchiveConfig{Compression: compression})
+	return err
+}
+
+// UnzipArchive extracts an archive file using ac.
+// When the archive carries a manifest, every extracted file is checked against its recorded hash.
+// Small files are written by a pool of ac.ExtractConcurrency workers while the archive is read;
+// symlinks and hard links are created only after every file before them is written, and no
+// later entry is written through a symlink the archive created.
+func UnzipArchive(filename string, ac ArchiveConfig) (*ExtractStats, error) {
 	start := time.Now()
 	file, err := os.Open(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
 
-	var tarReader *tar.Reader
-	var zr *zstd.Decoder
-
-	if compression == CompressionZstd {
-		zr, err = zstd.NewReader(file, zstd.WithDecoderConcurrency(runtime.NumCPU()))
-		if err != nil {
-			return err
+	// Progress follows the archive bytes consumed, the only size known up front
+	in := &progressReader{r: file, p: newProgress("extract", "", info.Size())}
+	plain, closeReader, err := newPlainReader(in, ac)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader()
+	// Time spent waiting for the tar stream is decryption and decompression; the rest is extraction
+	timed := &timingReader{r: plain}
+	tarReader := tar.NewReader(timed)
+	stats := &ExtractStats{}
+
+	var expected map[string]string
+	var verifyMu sync.Mutex
+	verify := func(name string, sum string) error {
+		verifyMu.Lock()
+		defer verifyMu.Unlock()
+		if expected == nil {
+			return nil
+		}
+		want, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("file %s is not listed in the archive manifest", name)
 		}
-		defer zr.Close()
-		tarReader = tar.NewReader(zr)
-	} else {
-		tarReader = tar.NewReader(file)
+		if sum != want {
+			return fmt.Errorf("checksum mismatch for %s: manifest %s, extracted %s", name, want, sum)
+		}
+		delete(expected, name)
+		return nil
 	}
+	pool := newExtractPool(ac.ExtractConcurrency, verify)
+	defer pool.close()
 
-	var fileCount int
+	// Names differing only in case overwrite each other on case-insensitive file systems
+	written := make(map[string]string)
+	links := linkGuard{}
 	for {
 		header, err := tarReader.Next()
 
@@ -220,47 +379,180 @@ func Unzip(filename string, compression string) error {
 		}
 
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if err := pool.failed(); err != nil {
+			return nil, err
 		}
-		target := filepath.ToSlash(header.Name)
 
-		if header.Typeflag == tar.TypeReg {
-			// Create the directory that contains it
-			dir := filepath.Dir(target)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		if header.Name == manifestEntryName {
+			manifest, err := readManifest(tarReader)
+			if err != nil {
+				return nil, err
 			}
+			verifyMu.Lock()
+			expected = manifest.fileHashes()
+			verifyMu.Unlock()
+			continue
+		}
 
-			// Write the file
-			if err := extractFile(target, header, tarReader); err != nil {
-				return err
+		target, err := ac.extractTarget(header.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := links.check(target, header); err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create directory %s: %w", target, err)
+			}
+		case tar.TypeReg:
+			seen := target
+			if caseInsensitiveFS() {
+				seen = strings.ToLower(target)
+			}
+			if other, ok := written[seen]; ok {
+				if other != header.Name {
+					slog.Warn("archived files differ only in case, the later one wins", "file", header.Name, "other", other)
+				}
+				// The earlier file must be written first for the later one to win
+				if err := pool.wait(); err != nil {
+					return nil, err
+				}
+			}
+			written[seen] = header.Name
+
+			// Small files are buffered for the workers, large ones are streamed here
+			if header.Size <= readAheadFileSize {
+				data, err := io.ReadAll(tarReader)
+				if err != nil {
+					return nil, err
+				}
+				pool.submit(extractJob{target: target, header: header, data: data})
+			} else if err := writeExtractedFile(target, header, tarReader, verify); err != nil {
+				return nil, err
+			}
+			stats.Files++
+			stats.Bytes += header.Size
+		case tar.TypeSymlink, tar.TypeLink:
+			// Links wait for every file before them, so a hard link finds its target written
+			// and no earlier file is written through a link created after it
+			if err := pool.wait(); err != nil {
+				return nil, err
+			}
+			if err := extractLink(ac, target, header); err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeSymlink {
+				links[filepath.Clean(target)] = true
 			}
-			fileCount++
 		}
 	}
-	elapsed := time.Since(start)
-	slog.Info("successfully unzipped", "filename", filename, "files", fileCount, "duration", elapsed)
-	return nil
+	if err := pool.close(); err != nil {
+		return nil, err
+	}
+	if len(expected) > 0 {
+		return nil, fmt.Errorf("archive is missing %d files listed in its manifest", len(expected))
+	}
+	stats.Decompress = timed.elapsed
+	stats.Extract = time.Since(start) - timed.elapsed
+	slog.Info("successfully unzipped", "filename", filename, "files", stats.Files, "duration", time.Since(start))
+	return stats, nil
+}
+
+// ExtractStats describes an extracted archive.
+type ExtractStats struct {
+	Files      int
+	Bytes      int64         // uncompressed size of the extracted files
+	Decompress time.Duration // reading, decrypting and decompressing the archive
+	Extract    time.Duration // writing and verifying files
+}
+
+// timingReader accumulates the time spent in Read.
+type timingReader struct {
+	r       io.Reader
+	elapsed time.Duration
+}
+
+func (t *timingReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.elapsed += time.Since(start)
+	return n, err
+}
+
+// timingWriter accumulates the time spent in Write and counts the bytes written.
+type timingWriter struct {
+	w       io.Writer
+	n       int64
+	elapsed time.Duration
 }
 
-// extractFile extracts a single file from the tar reader
-func extractFile(target string, header *tar.Header, tarReader *tar.Reader) error {
-	fileToWrite, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode))
+func (t *timingWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.elapsed += time.Since(start)
+	t.n += int64(n)
+	return n, err
+}
+
+// ReadArchiveHeaders streams an archive and calls fn for every tar header without
+// extracting anything. File contents are skipped. The embedded manifest, if present,
+// is decoded and returned instead of being passed to fn.
+func ReadArchiveHeaders(r io.Reader, ac ArchiveConfig, fn func(*tar.Header) error) (*Manifest, error) {
+	tarReader, closeReader, err := newArchiveReader(r, ac)
 	if err != nil {
-		return fmt.Errorf("failed creating %s: %w", target, err)
+		return nil, err
+	}
+	defer closeReader()
+
+	var manifest *Manifest
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		if header.Name == manifestEntryName {
+			manifest, err = readManifest(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := fn(header); err != nil {
+			return manifest, err
+		}
+	}
+}
+
+// extractFile extracts a single file from the tar reader and returns the
+// hex-encoded SHA-256 of the written contents. Only the permission bits of the
+// archived mode are applied.
+func extractFile(target string, header *tar.Header, r io.Reader) (string, error) {
+	fileToWrite, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+	if err != nil {
+		return "", fmt.Errorf("failed creating %s: %w", target, err)
 	}
 	defer fileToWrite.Close()
 
 	// Copy over contents
-	if _, err := io.Copy(fileToWrite, tarReader); err != nil {
-		return fmt.Errorf("failed copying contents to %s: %w", target, err)
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(fileToWrite, h), r); err != nil {
+		return "", fmt.Errorf("failed copying contents to %s: %w", target, err)
 	}
 
-	if err := os.Chtimes(header.Name, header.AccessTime, header.ModTime); err != nil {
-		return fmt.Errorf("failed setting timestamps to %s: %w", target, err)
+	if err := os.Chtimes(target, header.AccessTime, header.ModTime); err != nil {
+		return "", fmt.Errorf("failed setting timestamps to %s: %w", target, err)
 	}
 
-	return nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func getReadableBytes(b int64) string {
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"archive/tar"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -594,3 +596,214 @@ func TestZipStreamNoCompression(t *testing.T) {
 		t.Errorf("content mismatch: got %q, want %q", string(content), testContent)
 	}
 }
+
+func TestReadArchiveHeaders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "read_headers_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("headers/sub", 0755)
+	os.WriteFile("headers/a.txt", []byte("aaa"), 0644)
+	os.WriteFile("headers/sub/b.txt", []byte("bbbb"), 0644)
+
+	if err := Zip("headers.tar.zst", []string{"headers"}, CompressionZstd, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+
+	f, err := os.Open("headers.tar.zst")
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+
+	sizes := map[string]int64{}
+	manifest, err := ReadArchiveHeaders(f, ArchiveConfig{Compression: CompressionZstd}, func(h *tar.Header) error {
+		if h.Typeflag == tar.TypeReg {
+			sizes[h.Name] = h.Size
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadArchiveHeaders failed: %v", err)
+	}
+	if manifest == nil || manifest.FileCount != 2 {
+		t.Fatalf("expected manifest with 2 files, got %+v", manifest)
+	}
+	if sizes["headers/a.txt"] != 3 || sizes["headers/sub/b.txt"] != 4 {
+		t.Errorf("unexpected file sizes: %v", sizes)
+	}
+	if _, err := os.Stat("headers.tar.zst"); err != nil {
+		t.Errorf("archive should be untouched: %v", err)
+	}
+}
+
+func TestUnzipArchiveStats(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "unzip_stats_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("stats/sub", 0755)
+	os.WriteFile("stats/a.txt", []byte("aaa"), 0644)
+	os.WriteFile("stats/sub/b.txt", []byte("bbbb"), 0644)
+
+	if err := Zip("stats.tar.zst", []string{"stats"}, CompressionZstd, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	os.RemoveAll("stats")
+
+	stats, err := UnzipArchive("stats.tar.zst", ArchiveConfig{Compression: CompressionZstd})
+	if err != nil {
+		t.Fatalf("UnzipArchive failed: %v", err)
+	}
+	if stats.Files != 2 || stats.Bytes != 7 {
+		t.Errorf("stats = %+v, want 2 files and 7 bytes", stats)
+	}
+	if stats.Decompress <= 0 || stats.Extract <= 0 {
+		t.Errorf("expected both phases to be timed, got %+v", stats)
+	}
+}
+
+func TestZipRewriteAndRestoreRoot(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("checkout/deps", 0755)
+	os.WriteFile("checkout/deps/lib.txt", []byte("lib"), 0644)
+
+	// The same rules serve put and get: stored under a stable name, restored to the original path
+	rules := PathRewrites{{From: "checkout", To: "workspace"}}
+	put := ArchiveConfig{Compression: CompressionZstd, Rewrites: rules}
+	if err := ZipArchive("moved.tar.zst", []string{"checkout"}, put); err != nil {
+		t.Fatalf("ZipArchive failed: %v", err)
+	}
+	f, err := os.Open("moved.tar.zst")
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	manifest, err := ReadArchiveHeaders(f, put, func(*tar.Header) error { return nil })
+	f.Close()
+	if err != nil || manifest.fileHashes()["workspace/deps/lib.txt"] == "" {
+		t.Fatalf("expected the rewritten name in the archive, got %v", err)
+	}
+
+	get := ArchiveConfig{Compression: CompressionZstd, Rewrites: rules, RestoreRoot: "restored"}
+	if _, err := UnzipArchive("moved.tar.zst", get); err != nil {
+		t.Fatalf("UnzipArchive failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join("restored", "checkout", "deps", "lib.txt"))
+	if err != nil || string(data) != "lib" {
+		t.Errorf("expected the file at its original path under the restore root, got %q, %v", data, err)
+	}
+
+	// Another checkout maps the stored name to its own path
+	get.Rewrites = PathRewrites{{From: "other", To: "workspace"}}
+	if _, err := UnzipArchive("moved.tar.zst", get); err != nil {
+		t.Fatalf("UnzipArchive failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join("restored", "other", "deps", "lib.txt")); err != nil {
+		t.Errorf("expected the file in the other checkout: %v", err)
+	}
+}
+
+func TestZipHomeAndAbsolutePaths(t *testing.T) {
+	workspace, home, outside := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(workspace); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll(filepath.Join(home, ".m2"), 0755)
+	os.WriteFile(filepath.Join(home, ".m2", "dep.jar"), []byte("jar"), 0644)
+	os.WriteFile(filepath.Join(outside, "tool"), []byte("tool"), 0644)
+	t.Setenv("TOOL_DIR", outside)
+
+	manifest, err := BuildManifest([]string{"~/.m2", "$TOOL_DIR/tool"}, CompressionZstd)
+	if err != nil {
+		t.Fatalf("BuildManifest failed: %v", err)
+	}
+	names := map[string]bool{}
+	for _, f := range manifest.Files {
+		names[f.Path] = true
+	}
+	if !names["~/.m2/dep.jar"] || !names["/"+strings.TrimPrefix(filepath.ToSlash(outside), "/")+"/tool"] {
+		t.Fatalf("expected home and absolute names, got %v", names)
+	}
+
+	if err := Zip("abs.tar.zst", []string{"~/.m2", "$TOOL_DIR/tool"}, CompressionZstd, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+
+	// Restore on a runner with a different home directory
+	newHome := t.TempDir()
+	t.Setenv("HOME", newHome)
+	os.Remove(filepath.Join(outside, "tool"))
+	if err := Unzip("abs.tar.zst", CompressionZstd); err != nil {
+		t.Fatalf("Unzip failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(newHome, ".m2", "dep.jar")); err != nil || string(data) != "jar" {
+		t.Errorf("expected the home file in the new home, got %q, %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(outside, "tool")); err != nil || string(data) != "tool" {
+		t.Errorf("expected the absolute file restored in place, got %q, %v", data, err)
+	}
+}
+
+func TestZipCrossOSModes(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("tools", 0700)
+	os.WriteFile("tools/run.sh", []byte("#!/bin/sh\n"), 0700)
+	os.WriteFile("tools/notes.txt", []byte("notes"), 0600)
+
+	ac := ArchiveConfig{Compression: CompressionZstd, CrossOS: true}
+	if err := ZipArchive("portable.tar.zst", []string{"tools"}, ac); err != nil {
+		t.Fatalf("ZipArchive failed: %v", err)
+	}
+	f, err := os.Open("portable.tar.zst")
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+
+	modes := map[string]int64{}
+	manifest, err := ReadArchiveHeaders(f, ac, func(h *tar.Header) error {
+		modes[h.Name] = h.Mode
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadArchiveHeaders failed: %v", err)
+	}
+	if !manifest.CrossOS || manifest.OS == "" {
+		t.Errorf("expected the manifest to record a cross-OS archive, got os %q cross_os %v", manifest.OS, manifest.CrossOS)
+	}
+	if modes["tools"] != 0755 || modes["tools/run.sh"] != 0755 || modes["tools/notes.txt"] != 0644 {
+		t.Errorf("unexpected modes %v", modes)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// CacheFile - Config file describing several named caches handled in one invocation.
+	// Settings shared by all caches, such as the bucket and encryption, still come from
+	// flags and environment variables.
+	CacheFile struct {
+		Caches []CacheFileEntry `yaml:"caches"`
+	}
+
+	// CacheFileEntry - A single named cache. Keys are given without the key prefix and extension,
+	// like KEY and DEFAULT_KEY.
+	CacheFileEntry struct {
+		Name             string   `yaml:"name"`
+		Key              string   `yaml:"key"`
+		RestoreKeys      []string `yaml:"restore-keys"`
+		Artifacts        []string `yaml:"artifacts"`
+		Compression      string   `yaml:"compression"`
+		CompressionLevel int      `yaml:"compression-level"`
+	}
+)
+
+// LoadCacheFile reads and decodes a YAML cache file. Unknown fields are rejected so typos
+// do not silently change what gets cached.
+func LoadCacheFile(path string) (*CacheFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cf CacheFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cf); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(cf.Caches) == 0 {
+		return nil, fmt.Errorf("config file %s defines no caches", path)
+	}
+	return &cf, nil
+}
+
+// resolve derives one Action per entry from base, which supplies every setting an entry
+// does not override. Keys are evaluated as key templates. Entries without restore keys only
+// match their exact key.
+func (cf *CacheFile) resolve(base Action, kt *keyTemplater) ([]NamedCache, error) {
+	caches := make([]NamedCache, 0, len(cf.Caches))
+	for _, e := range cf.Caches {
+		key, err := kt.expand("cache "+e.Name+" key", e.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		a := base
+		a.Caches = nil
+		if e.Compression != "" {
+			a.Compression = e.Compression
+		}
+		if e.CompressionLevel != 0 {
+			a.CompressionLevel = e.CompressionLevel
+		}
+		a.Key = base.KeyPrefix + key + keyExtension(a.Compression)
+		a.DefaultKey = base.KeyPrefix
+		a.RestoreKeys = nil
+		for _, rk := range e.RestoreKeys {
+			rk, err := kt.expand("cache "+e.Name+" restore key", rk)
+			if err != nil {
+				return nil, err
+			}
+			a.RestoreKeys = append(a.RestoreKeys, base.KeyPrefix+rk)
+		}
+		if len(a.RestoreKeys) > 0 {
+			a.DefaultKey = a.RestoreKeys[0]
+		}
+		a.Artifacts = e.Artifacts
+		caches = append(caches, NamedCache{Name: e.Name, Action: a})
+	}
+	return caches, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testCacheFile = `
+caches:
+  - name: yarn
+    key: linux-yarn-abc
+    restore-keys:
+      - linux-yarn-
+    artifacts:
+      - node_modules
+  - name: ccache
+    key: linux-ccache-abc
+    artifacts: [.ccache]
+    compression: none
+`
+
+func writeCacheFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "caches.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadCacheFile(t *testing.T) {
+	cf, err := LoadCacheFile(writeCacheFile(t, testCacheFile))
+	if err != nil {
+		t.Fatalf("LoadCacheFile failed: %v", err)
+	}
+	if len(cf.Caches) != 2 || cf.Caches[1].Compression != CompressionNone {
+		t.Errorf("unexpected caches: %+v", cf.Caches)
+	}
+
+	if _, err := LoadCacheFile(writeCacheFile(t, "caches:\n  - name: yarn\n    restore_keys: [a]\n")); err == nil {
+		t.Error("expected error for unknown field")
+	}
+	if _, err := LoadCacheFile(writeCacheFile(t, "")); err == nil {
+		t.Error("expected error for file without caches")
+	}
+}
+
+func TestCacheFileResolve(t *testing.T) {
+	cf, err := LoadCacheFile(writeCacheFile(t, testCacheFile))
+	if err != nil {
+		t.Fatalf("LoadCacheFile failed: %v", err)
+	}
+	base := Action{
+		Action:      GetAction,
+		Bucket:      "b",
+		KeyPrefix:   "acme/widgets/",
+		Compression: CompressionZstd,
+		RestoreKeys: []string{"acme/widgets/"},
+	}
+	caches, err := cf.resolve(base, newKeyTemplater())
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+
+	yarn := caches[0].Action
+	if yarn.Key != "acme/widgets/linux-yarn-abc.tar.zst" {
+		t.Errorf("yarn Key = %q", yarn.Key)
+	}
+	if !reflect.DeepEqual(yarn.RestoreKeys, []string{"acme/widgets/linux-yarn-"}) {
+		t.Errorf("yarn RestoreKeys = %v", yarn.RestoreKeys)
+	}
+
+	ccache := caches[1].Action
+	if ccache.Key != "acme/widgets/linux-ccache-abc.tar" || ccache.Compression != CompressionNone {
+		t.Errorf("ccache Key = %q, Compression = %q", ccache.Key, ccache.Compression)
+	}
+	if len(ccache.RestoreKeys) != 0 {
+		t.Errorf("entry without restore keys should only match its exact key, got %v", ccache.RestoreKeys)
+	}
+	if ccache.Bucket != "b" {
+		t.Error("entries should inherit shared settings from the base action")
+	}
+}
+
+func TestParseActionConfigFile(t *testing.T) {
+	for _, k := range []string{"ACTION", "BUCKET", "KEY", "KEY_PREFIX", "ARTIFACTS", "CONFIG_FILE", "STRICT"} {
+		t.Setenv(k, "")
+	}
+	os.Setenv("ACTION", "put")
+	os.Setenv("BUCKET", "b")
+	os.Setenv("CONFIG_FILE", writeCacheFile(t, testCacheFile))
+
+	action, err := ParseAction()
+	if err != nil {
+		t.Fatalf("ParseAction failed: %v", err)
+	}
+	if len(action.Caches) != 2 || action.Caches[0].Name != "yarn" {
+		t.Errorf("unexpected caches: %+v", action.Caches)
+	}
+
+	os.Setenv("CONFIG_FILE", writeCacheFile(t, testCacheFile+"  - name: yarn\n    key: other\n"))
+	_, err = ParseAction()
+	if err == nil || !strings.Contains(err.Error(), "duplicate") || !strings.Contains(err.Error(), "ARTIFACTS") {
+		t.Errorf("expected duplicate name and missing artifacts errors, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// OutputCaches is the step output holding per-cache results as a JSON object keyed by name.
+const OutputCaches = "caches"
+
+// runCaches runs the action for every named cache concurrently and reports per-cache outputs.
+// All caches run to completion; their errors are returned together.
+func runCaches(ctx context.Context, action Action, tc TransferConfig) error {
+	results := make([]GetResult, len(action.Caches))
+	reports := make([]CacheReport, len(action.Caches))
+	errs := make([]error, len(action.Caches))
+
+	var wg sync.WaitGroup
+	for i, c := range action.Caches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info("processing cache", "name", c.Name, "action", action.Action)
+			cacheCtx, span := StartSpan(ctx, "cache", "name", c.Name)
+			defer span.End()
+			reports[i].Name = c.Name
+			var err error
+			switch action.Action {
+			case PutAction:
+				err = saveCache(cacheCtx, c.Action, tc, &reports[i])
+			case GetAction:
+				results[i], err = restoreCache(cacheCtx, c.Action, tc, &reports[i])
+			case DeleteAction:
+				err = runDelete(c.Action)
+			}
+			span.SetError(err)
+			if err != nil {
+				errs[i] = fmt.Errorf("cache %q: %w", c.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if action.Action == GetAction {
+		setCachesOutputs(action.Caches, results)
+	}
+	if action.Action != DeleteAction {
+		WriteStepSummary(action.Action, reports)
+		recordMetrics(action, reports)
+	}
+	return errors.Join(errs...)
+}
+
+// setCachesOutputs writes the per-cache results as a JSON object, and sets cache-hit
+// to true only if every cache was restored from its exact key.
+func setCachesOutputs(caches []NamedCache, results []GetResult) {
+	byName := make(map[string]GetResult, len(caches))
+	allHit := true
+	for i, c := range caches {
+		byName[c.Name] = results[i]
+		allHit = allHit && results[i].Hit
+	}
+
+	data, err := json.Marshal(byName)
+	if err != nil {
+		slog.Warn("failed to encode cache outputs", "error", err)
+		return
+	}
+	outputs := [][2]string{
+		{OutputCacheHit, fmt.Sprint(allHit)},
+		{OutputCaches, string(data)},
+	}
+	for _, o := range outputs {
+		if err := SetOutput(o[0], o[1]); err != nil {
+			slog.Warn("failed to set output", "name", o[0], "error", err)
+		}
+	}
+}
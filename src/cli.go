@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Configuration precedence, highest first:
+//
+//  1. command-line flags
+//  2. environment variables (the GitHub Action passes its inputs this way)
+//  3. built-in defaults
+//
+// Every flag maps onto exactly one environment variable. ParseArgs copies explicitly set
+// flags into the environment, so ParseAction remains the single place configuration is
+// validated and the AWS SDK sees flags such as --region as well.
+
+// cliFlag - A command-line flag and the environment variable it overrides
+type cliFlag struct {
+	name  string
+	env   string
+	usage string
+	bool  bool // may be given without a value, e.g. --dry-run
+	multi bool // may be repeated; values are joined with newlines
+}
+
+var cliFlags = []cliFlag{
+	{name: "config", env: "CONFIG_FILE", usage: "YAML file describing several named caches to process at once"},
+	{name: "bucket", env: "BUCKET", usage: "S3 bucket holding the caches"},
+	{name: "region", env: "AWS_REGION", usage: "AWS region of the bucket"},
+	{name: "endpoint", env: "AWS_S3_ENDPOINT", usage: "custom S3 endpoint, e.g. for MinIO"},
+	{name: "key", env: "KEY", usage: "cache key, without extension; may be a key template"},
+	{name: "default-key", env: "DEFAULT_KEY", usage: "key prefix to restore the latest similar cache from"},
+	{name: "key-prefix", env: "KEY_PREFIX", usage: "namespace prepended to every key (default $GITHUB_REPOSITORY)"},
+	{name: "prefix", env: "PREFIX", usage: "key prefix for list and prune (default --default-key)"},
+	{name: "artifacts", env: "ARTIFACTS", usage: "glob pattern of paths to cache; may be repeated", multi: true},
+	{name: "s3-class", env: "S3_CLASS", usage: "storage class for uploads"},
+	{name: "compression", env: "COMPRESSION", usage: "zstd or none"},
+	{name: "compression-level", env: "COMPRESSION_LEVEL", usage: "zstd level, 1-19"},
+	{name: "path-rewrite", env: "PATH_REWRITES", usage: "path=name prefix rewrite between paths and archived names; may be repeated", multi: true},
+	{name: "restore-root", env: "RESTORE_ROOT", usage: "get: extract into this directory instead of the current one"},
+	{name: "cross-os-archive", env: "CROSS_OS_ARCHIVE", usage: "share caches between Linux, macOS and Windows runners", bool: true},
+	{name: "reproducible", env: "REPRODUCIBLE", usage: "put: create byte-identical archives for identical inputs", bool: true},
+	{name: "source-date-epoch", env: "SOURCE_DATE_EPOCH", usage: "put: Unix time reproducible archives clamp timestamps to"},
+	{name: "archive-concurrency", env: "ARCHIVE_CONCURRENCY", usage: "files read in parallel while archiving"},
+	{name: "extract-concurrency", env: "EXTRACT_CONCURRENCY", usage: "files written in parallel while extracting"},
+	{name: "upload-concurrency", env: "UPLOAD_CONCURRENCY", usage: "parallel upload parts"},
+	{name: "download-concurrency", env: "DOWNLOAD_CONCURRENCY", usage: "parallel download parts"},
+	{name: "upload-part-size", env: "UPLOAD_PART_SIZE", usage: "upload part size, e.g. 64MiB"},
+	{name: "download-part-size", env: "DOWNLOAD_PART_SIZE", usage: "download part size, e.g. 64MiB"},
+	{name: "encryption-key-file", env: "ENCRYPTION_KEY_FILE", usage: "file of client-side encryption keys"},
+	{name: "allow-unencrypted", env: "ALLOW_UNENCRYPTED", usage: "get: restore caches that are not encrypted despite encryption keys", bool: true},
+	{name: "sse", env: "SSE", usage: "server-side encryption: AES256, aws:kms or aws:kms:dsse"},
+	{name: "sse-kms-key-id", env: "SSE_KMS_KEY_ID", usage: "KMS key for aws:kms"},
+	{name: "sse-bucket-key", env: "SSE_BUCKET_KEY", usage: "use an S3 bucket key with aws:kms", bool: true},
+	{name: "signing-key-file", env: "SIGNING_KEY_FILE", usage: "file holding the ed25519 signing key"},
+	{name: "trusted-keys-file", env: "TRUSTED_KEYS_FILE", usage: "file of trusted ed25519 public keys"},
+	{name: "scope", env: "SCOPE", usage: "global or branch"},
+	{name: "ref", env: "GITHUB_REF", usage: "git ref for branch scope, e.g. refs/heads/main"},
+	{name: "base-ref", env: "GITHUB_BASE_REF", usage: "pull request base branch for branch scope"},
+	{name: "default-branch", env: "DEFAULT_BRANCH", usage: "default branch for branch scope (default main)"},
+	{name: "lookup-only", env: "LOOKUP_ONLY", usage: "get: only check whether a cache exists", bool: true},
+	{name: "fail-on-cache-miss", env: "FAIL_ON_CACHE_MISS", usage: "get: fail when no usable cache is found", bool: true},
+	{name: "track-access", env: "TRACK_ACCESS", usage: "get: record restores for prune; needs write access", bool: true},
+	{name: "prune-max-age", env: "PRUNE_MAX_AGE", usage: "prune: evict caches unused for longer, e.g. 30d"},
+	{name: "prune-max-total-size", env: "PRUNE_MAX_TOTAL_SIZE", usage: "prune: evict until the prefix fits, e.g. 50GB"},
+	{name: "prune-keep-newest", env: "PRUNE_KEEP_NEWEST", usage: "prune: always keep this many newest caches per group"},
+	{name: "prune-group-delimiter", env: "PRUNE_GROUP_DELIMITER", usage: "prune: delimiter splitting keys into groups"},
+	{name: "dry-run", env: "DRY_RUN", usage: "report changes without making them", bool: true},
+	{name: "progress-interval", env: "PROGRESS_INTERVAL", usage: "time between progress events of long transfers (default 10s)"},
+	{name: "report-file", env: "REPORT_FILE", usage: "write per-phase timings as JSON to this file"},
+	{name: "otlp-endpoint", env: "OTEL_EXPORTER_OTLP_ENDPOINT", usage: "send phase timings as a trace to this OTLP/HTTP endpoint"},
+	{name: "metrics-textfile", env: "METRICS_TEXTFILE", usage: "add cache metrics to this Prometheus textfile"},
+	{name: "metrics-pushgateway-url", env: "METRICS_PUSHGATEWAY_URL", usage: "push cache metrics to this Prometheus Pushgateway"},
+	{name: "metrics-job", env: "METRICS_JOB", usage: "Pushgateway job name (default s3_cache)"},
+	{name: "metrics-instance", env: "METRICS_INSTANCE", usage: "Pushgateway instance grouping label (default the key prefix)"},
+	{name: "strict", env: "STRICT", usage: "treat configuration warnings as errors", bool: true},
+	{name: "debug", env: "DEBUG", usage: "enable debug logging", bool: true},
+}
+
+// commands lists the subcommands in help output order.
+var commands = []struct{ name, usage string }{
+	{PutAction, "archive artifacts and upload them under --key"},
+	{GetAction, "restore --key, or the latest cache matching --default-key"},
+	{DeleteAction, "delete --key"},
+	{ListAction, "list caches under --prefix"},
+	{InspectAction, "show the metadata and contents of --key"},
+	{PruneAction, "delete caches under --prefix according to the prune policy"},
+}
+
+// flagValue records a flag's value and whether it was given on the command line.
+type flagValue struct {
+	def   cliFlag
+	value string
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(v string) error {
+	if f.def.multi && f.value != "" {
+		f.value += "\n" + v
+		return nil
+	}
+	f.value = v
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.def.bool
+}
+
+// ParseArgs parses "[command] [flags]" and copies the command and every explicitly set flag
+// into the environment, overriding any existing value. With no command, ACTION from the
+// environment is used. Returns flag.ErrHelp if help was requested.
+func ParseArgs(args []string) error {
+	fs := flag.NewFlagSet("s3cache", flag.ContinueOnError)
+	fs.Usage = func() { printUsage(fs.Output()) }
+
+	var command string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+		if command == "help" {
+			fs.Usage()
+			return flag.ErrHelp
+		}
+		if !slices.ContainsFunc(commands, func(c struct{ name, usage string }) bool { return c.name == command }) {
+			return fmt.Errorf("unknown command %q, run with --help for usage", command)
+		}
+	}
+
+	values := make([]*flagValue, len(cliFlags))
+	for i, def := range cliFlags {
+		values[i] = &flagValue{def: def}
+		fs.Var(values[i], def.name, def.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q, run with --help for usage", fs.Arg(0))
+	}
+
+	if command != "" {
+		os.Setenv("ACTION", command)
+	}
+	var setErr error
+	fs.Visit(func(f *flag.Flag) {
+		v := f.Value.(*flagValue)
+		if err := os.Setenv(v.def.env, v.value); err != nil && setErr == nil {
+			setErr = fmt.Errorf("failed to apply --%s: %w", f.Name, err)
+		}
+	})
+	return setErr
+}
+
+// printUsage writes the commands and flags, with the environment variable behind each flag.
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "Usage: s3cache <command> [flags]\n\nCommands:\n")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.usage)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nFlags override the environment variable shown, which in turn overrides the default:\n")
+	for _, def := range cliFlags {
+		name := "--" + def.name
+		if !def.bool {
+			name += " value"
+		}
+		fmt.Fprintf(w, "  %s\t$%s\t%s\n", name, def.env, def.usage)
+	}
+	w.Flush()
+}
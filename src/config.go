@@ -1,43 +1,127 @@
 package main
 
 import (
-	"fmt"
-	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ParseAction reads all configuration from environment variables,
 // validates it, and returns a fully populated Action.
+// Every problem found is reported in a single error. Values that are malformed but have a safe
+// default are logged as warnings instead, unless STRICT is set.
 func ParseAction() (Action, error) {
+	p := &configProblems{}
+
 	compression := os.Getenv("COMPRESSION")
 	if compression == "" {
 		compression = CompressionZstd
 	}
-	if compression != CompressionZstd && compression != CompressionNone {
-		return Action{}, fmt.Errorf("invalid compression mode %q, valid options: %s, %s",
-			compression, CompressionZstd, CompressionNone)
-	}
+
+	keyring, err := ParseKeyring(os.Getenv("ENCRYPTION_KEY"), os.Getenv("ENCRYPTION_KEY_FILE"))
+	p.check(err)
+
+	sse, err := ParseServerSideEncryption(os.Getenv("SSE"), os.Getenv("SSE_KMS_KEY_ID"),
+		p.boolEnv("SSE_BUCKET_KEY"), os.Getenv("SSE_CUSTOMER_KEY"))
+	p.check(err)
+
+	signer, err := ParseSigner(os.Getenv("SIGNING_KEY"), os.Getenv("SIGNING_KEY_FILE"))
+	p.check(err)
+	trustedKeys, err := ParseTrustedKeys(os.Getenv("TRUSTED_KEYS"), os.Getenv("TRUSTED_KEYS_FILE"))
+	p.check(err)
+
+	rewrites, err := ParsePathRewrites(os.Getenv("PATH_REWRITES"))
+	p.check(err)
+
+	scope, err := ParseScope(os.Getenv("SCOPE"), os.Getenv("GITHUB_REF"), os.Getenv("GITHUB_BASE_REF"), os.Getenv("DEFAULT_BRANCH"))
+	p.check(err)
+
+	keyPrefix := keyPrefixFromEnv()
+	kt := newKeyTemplater()
+	key, err := kt.expand("KEY", os.Getenv("KEY"))
+	p.check(err)
+	defaultKey, err := kt.expand("DEFAULT_KEY", os.Getenv("DEFAULT_KEY"))
+	p.check(err)
 
 	action := Action{
 		Action:              os.Getenv("ACTION"),
 		Bucket:              os.Getenv("BUCKET"),
 		S3Class:             os.Getenv("S3_CLASS"),
-		Key:                 os.Getenv("KEY") + keyExtension(compression),
-		DefaultKey:          os.Getenv("DEFAULT_KEY"),
+		Key:                 keyPrefix + key + keyExtension(compression),
+		DefaultKey:          keyPrefix + defaultKey,
+		Prefix:              keyPrefix + os.Getenv("PREFIX"),
+		KeyPrefix:           keyPrefix,
 		Artifacts:           strings.Split(strings.TrimSpace(os.Getenv("ARTIFACTS")), "\n"),
 		Compression:         compression,
-		CompressionLevel:    parseIntEnv("COMPRESSION_LEVEL"),
-		UploadConcurrency:   parseIntEnv("UPLOAD_CONCURRENCY"),
-		DownloadConcurrency: parseIntEnv("DOWNLOAD_CONCURRENCY"),
-		UploadPartSize:      parseByteSize("UPLOAD_PART_SIZE"),
-		DownloadPartSize:    parseByteSize("DOWNLOAD_PART_SIZE"),
+		CompressionLevel:    p.intEnv("COMPRESSION_LEVEL"),
+		PathRewrites:        rewrites,
+		RestoreRoot:         os.Getenv("RESTORE_ROOT"),
+		CrossOSArchive:      p.boolEnv("CROSS_OS_ARCHIVE"),
+		Reproducible:        p.boolEnv("REPRODUCIBLE"),
+		SourceDateEpoch:     p.epochEnv("SOURCE_DATE_EPOCH"),
+		Keyring:             keyring,
+		AllowUnencrypted:    p.boolEnv("ALLOW_UNENCRYPTED"),
+		SSE:                 sse,
+		Signer:              signer,
+		TrustedKeys:         trustedKeys,
+		Scope:               scope,
+		ArchiveConcurrency:  p.intEnv("ARCHIVE_CONCURRENCY"),
+		ExtractConcurrency:  p.intEnv("EXTRACT_CONCURRENCY"),
+		UploadConcurrency:   p.intEnv("UPLOAD_CONCURRENCY"),
+		DownloadConcurrency: p.intEnv("DOWNLOAD_CONCURRENCY"),
+		UploadPartSize:      p.byteSizeEnv("UPLOAD_PART_SIZE"),
+		DownloadPartSize:    p.byteSizeEnv("DOWNLOAD_PART_SIZE"),
+		Prune: PrunePolicy{
+			MaxAge:         p.durationEnv("PRUNE_MAX_AGE"),
+			MaxTotalSize:   p.byteSizeEnv("PRUNE_MAX_TOTAL_SIZE"),
+			KeepNewest:     p.intEnv("PRUNE_KEEP_NEWEST"),
+			GroupDelimiter: os.Getenv("PRUNE_GROUP_DELIMITER"),
+		},
+		DryRun:           p.boolEnv("DRY_RUN"),
+		TrackAccess:      p.boolEnv("TRACK_ACCESS"),
+		LookupOnly:       p.boolEnv("LOOKUP_ONLY"),
+		FailOnCacheMiss:  p.boolEnv("FAIL_ON_CACHE_MISS"),
+		ProgressInterval: p.durationEnv("PROGRESS_INTERVAL"),
+		Telemetry:        ParseTelemetry(p),
+		Metrics:          ParseMetrics(p),
+	}
+
+	if action.Prefix == keyPrefix {
+		action.Prefix = action.DefaultKey
+	}
+	action.RestoreKeys = []string{action.DefaultKey}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		cf, err := LoadCacheFile(path)
+		if err == nil {
+			action.Caches, err = cf.resolve(action, kt)
+		}
+		p.check(err)
 	}
 
+	validateAction(action, p)
+	if err := p.err(p.boolEnv("STRICT")); err != nil {
+		return Action{}, err
+	}
 	return action, nil
 }
 
+// keyPrefixFromEnv returns the namespace prepended to every key. KEY_PREFIX falls back to
+// GITHUB_REPOSITORY when unset, so repositories sharing a bucket never see each other's caches;
+// setting it to an empty string disables prefixing. Non-empty prefixes always end in "/".
+func keyPrefixFromEnv() string {
+	prefix, ok := os.LookupEnv("KEY_PREFIX")
+	if !ok {
+		prefix = os.Getenv("GITHUB_REPOSITORY")
+	}
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
 // keyExtension returns the file extension for the given compression mode.
 func keyExtension(compression string) string {
 	switch compression {
@@ -48,25 +132,90 @@ func keyExtension(compression string) string {
 	}
 }
 
-// parseIntEnv reads an environment variable as an integer.
-// Returns 0 (meaning "use default") if the variable is empty.
-func parseIntEnv(name string) int {
-	v := os.Getenv(name)
+// compressionFromKey infers the compression mode of a stored cache from its key extension.
+// Returns an empty string if the key does not look like a cache archive.
+func compressionFromKey(key string) string {
+	switch {
+	case strings.HasSuffix(key, keyExtension(CompressionZstd)):
+		return CompressionZstd
+	case strings.HasSuffix(key, keyExtension(CompressionNone)):
+		return CompressionNone
+	default:
+		return ""
+	}
+}
+
+// intEnv reads an environment variable as an integer.
+// Returns 0 (meaning "use default") if the variable is empty or invalid.
+func (p *configProblems) intEnv(name string) int {
+	v := strings.TrimSpace(os.Getenv(name))
 	if v == "" {
 		return 0
 	}
 	n, err := strconv.Atoi(v)
 	if err != nil {
-		slog.Warn("invalid integer for env var, using default", "var", name, "value", v)
+		p.warnf("%s: invalid integer %q, using default", name, v)
 		return 0
 	}
 	return n
 }
 
-// parseByteSize parses a human-readable byte size string (e.g. "10MB", "5MiB", "100")
+// boolEnv reads an environment variable as a boolean.
+// Returns false if the variable is empty or not a valid boolean.
+func (p *configProblems) boolEnv(name string) bool {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.warnf("%s: invalid boolean %q, using default", name, v)
+		return false
+	}
+	return b
+}
+
+// durationEnv parses a duration such as "36h", "90m" or "30d".
+// In addition to the units accepted by time.ParseDuration, a "d" suffix means days.
+// Returns 0 if empty or invalid.
+func (p *configProblems) durationEnv(name string) time.Duration {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return 0
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil {
+			return time.Duration(n) * 24 * time.Hour
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.warnf("%s: invalid duration %q, using default", name, v)
+		return 0
+	}
+	return d
+}
+
+// epochEnv reads a Unix timestamp in seconds, as used by SOURCE_DATE_EPOCH.
+// Unset or malformed values return the zero time.
+func (p *configProblems) epochEnv(name string) time.Time {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return time.Time{}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		p.warnf("%s: invalid Unix timestamp %q, using the Unix epoch", name, v)
+		return time.Time{}
+	}
+	return time.Unix(n, 0).UTC()
+}
+
+// byteSizeEnv parses a human-readable byte size string (e.g. "10MB", "5MiB", "100")
 // into bytes. Supported suffixes: MB, MiB, GB, GiB (case-insensitive).
-// A plain number is treated as bytes. Returns 0 if empty.
-func parseByteSize(name string) int64 {
+// A plain number is treated as bytes. Returns 0 if empty or invalid.
+func (p *configProblems) byteSizeEnv(name string) int64 {
 	v := strings.TrimSpace(os.Getenv(name))
 	if v == "" {
 		return 0
@@ -92,7 +241,7 @@ func parseByteSize(name string) int64 {
 
 	n, err := strconv.ParseInt(numStr, 10, 64)
 	if err != nil {
-		slog.Warn("invalid byte size for env var, using default", "var", name, "value", v)
+		p.warnf("%s: invalid byte size %q, using default", name, v)
 		return 0
 	}
 	return n * multiplier
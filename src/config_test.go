@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"os"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestKeyExtension(t *testing.T) {
@@ -22,7 +26,25 @@ func TestKeyExtension(t *testing.T) {
 	}
 }
 
-func TestParseIntEnv(t *testing.T) {
+func TestCompressionFromKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"linux-yarn-abc.tar.zst", CompressionZstd},
+		{"linux-yarn-abc.tar", CompressionNone},
+		{"linux-yarn-abc.tar.zst.manifest.json", ""},
+		{"linux-yarn-abc", ""},
+	}
+	for _, tt := range tests {
+		result := compressionFromKey(tt.key)
+		if result != tt.expected {
+			t.Errorf("compressionFromKey(%q) = %q, want %q", tt.key, result, tt.expected)
+		}
+	}
+}
+
+func TestIntEnv(t *testing.T) {
 	tests := []struct {
 		name     string
 		envValue string
@@ -44,15 +66,19 @@ func TestParseIntEnv(t *testing.T) {
 			}
 			defer os.Unsetenv(envKey)
 
-			result := parseIntEnv(envKey)
+			var p configProblems
+			result := p.intEnv(envKey)
 			if result != tt.expected {
-				t.Errorf("parseIntEnv(%q) with value %q = %d, want %d", envKey, tt.envValue, result, tt.expected)
+				t.Errorf("intEnv(%q) with value %q = %d, want %d", envKey, tt.envValue, result, tt.expected)
+			}
+			if wantWarning := tt.name == "invalid"; (len(p.warnings) > 0) != wantWarning {
+				t.Errorf("intEnv(%q) with value %q warnings = %v", envKey, tt.envValue, p.warnings)
 			}
 		})
 	}
 }
 
-func TestParseByteSize(t *testing.T) {
+func TestByteSizeEnv(t *testing.T) {
 	tests := []struct {
 		name     string
 		envValue string
@@ -79,9 +105,75 @@ func TestParseByteSize(t *testing.T) {
 			}
 			defer os.Unsetenv(envKey)
 
-			result := parseByteSize(envKey)
+			var p configProblems
+			result := p.byteSizeEnv(envKey)
+			if result != tt.expected {
+				t.Errorf("byteSizeEnv(%q) with value %q = %d, want %d", envKey, tt.envValue, result, tt.expected)
+			}
+			if wantWarning := tt.name == "invalid"; (len(p.warnings) > 0) != wantWarning {
+				t.Errorf("byteSizeEnv(%q) with value %q warnings = %v", envKey, tt.envValue, p.warnings)
+			}
+		})
+	}
+}
+
+func TestDurationEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected time.Duration
+	}{
+		{"empty", "", 0},
+		{"hours", "36h", 36 * time.Hour},
+		{"minutes", "90m", 90 * time.Minute},
+		{"days", "30d", 30 * 24 * time.Hour},
+		{"invalid", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envKey := "TEST_PARSE_DURATION_ENV"
+			if tt.envValue == "" {
+				os.Unsetenv(envKey)
+			} else {
+				os.Setenv(envKey, tt.envValue)
+			}
+			defer os.Unsetenv(envKey)
+
+			var p configProblems
+			result := p.durationEnv(envKey)
+			if result != tt.expected {
+				t.Errorf("durationEnv(%q) with value %q = %v, want %v", envKey, tt.envValue, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBoolEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected bool
+	}{
+		{"empty", "", false},
+		{"true", "true", true},
+		{"one", "1", true},
+		{"false", "false", false},
+		{"invalid", "yes please", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envKey := "TEST_PARSE_BOOL_ENV"
+			if tt.envValue == "" {
+				os.Unsetenv(envKey)
+			} else {
+				os.Setenv(envKey, tt.envValue)
+			}
+			defer os.Unsetenv(envKey)
+
+			var p configProblems
+			result := p.boolEnv(envKey)
 			if result != tt.expected {
-				t.Errorf("parseByteSize(%q) with value %q = %d, want %d", envKey, tt.envValue, result, tt.expected)
+				t.Errorf("boolEnv(%q) with value %q = %v, want %v", envKey, tt.envValue, result, tt.expected)
 			}
 		})
 	}
@@ -90,7 +182,9 @@ func TestParseByteSize(t *testing.T) {
 func TestParseAction(t *testing.T) {
 	// Save and restore all env vars
 	envVars := []string{
-		"ACTION", "BUCKET", "S3_CLASS", "KEY", "DEFAULT_KEY", "ARTIFACTS",
+		"ACTION", "BUCKET", "S3_CLASS", "KEY", "DEFAULT_KEY", "PREFIX", "ARTIFACTS", "TRACK_ACCESS",
+		"SCOPE", "GITHUB_REF", "GITHUB_BASE_REF", "DEFAULT_BRANCH", "KEY_PREFIX", "GITHUB_REPOSITORY",
+		"LOOKUP_ONLY", "FAIL_ON_CACHE_MISS", "STRICT", "PRUNE_MAX_AGE",
 		"COMPRESSION", "COMPRESSION_LEVEL",
 		"UPLOAD_CONCURRENCY", "DOWNLOAD_CONCURRENCY",
 		"UPLOAD_PART_SIZE", "DOWNLOAD_PART_SIZE",
@@ -116,6 +210,7 @@ func TestParseAction(t *testing.T) {
 		os.Setenv("ACTION", "put")
 		os.Setenv("BUCKET", "my-bucket")
 		os.Setenv("KEY", "my-key")
+		os.Setenv("ARTIFACTS", "node_modules")
 
 		action, err := ParseAction()
 		if err != nil {
@@ -127,6 +222,12 @@ func TestParseAction(t *testing.T) {
 		if action.Key != "my-key.tar.zst" {
 			t.Errorf("expected key %q, got %q", "my-key.tar.zst", action.Key)
 		}
+		if action.TrackAccess {
+			t.Error("expected access tracking to be disabled by default")
+		}
+		if action.LookupOnly || action.FailOnCacheMiss {
+			t.Error("expected lookup-only and fail-on-cache-miss to be disabled by default")
+		}
 	})
 
 	t.Run("compression_none", func(t *testing.T) {
@@ -150,6 +251,154 @@ func TestParseAction(t *testing.T) {
 		}
 	})
 
+	t.Run("prefix_defaults_to_default_key", func(t *testing.T) {
+		for _, k := range envVars {
+			os.Unsetenv(k)
+		}
+		os.Setenv("ACTION", "list")
+		os.Setenv("BUCKET", "b")
+		os.Setenv("DEFAULT_KEY", "linux-yarn")
+
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Prefix != "linux-yarn" {
+			t.Errorf("Prefix = %q, want %q", action.Prefix, "linux-yarn")
+		}
+
+		os.Setenv("PREFIX", "linux-")
+		action, err = ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Prefix != "linux-" {
+			t.Errorf("Prefix = %q, want %q", action.Prefix, "linux-")
+		}
+	})
+
+	t.Run("branch_scope", func(t *testing.T) {
+		for _, k := range envVars {
+			os.Unsetenv(k)
+		}
+		os.Setenv("ACTION", "put")
+		os.Setenv("BUCKET", "b")
+		os.Setenv("KEY", "k")
+		os.Setenv("ARTIFACTS", "node_modules")
+		os.Setenv("SCOPE", "branch")
+		os.Setenv("GITHUB_REF", "refs/heads/feature")
+
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.storeKey() != "heads/feature/k.tar.zst" {
+			t.Errorf("storeKey = %q, want %q", action.storeKey(), "heads/feature/k.tar.zst")
+		}
+
+		os.Unsetenv("GITHUB_REF")
+		if _, err := ParseAction(); err == nil {
+			t.Error("expected error for branch scope without GITHUB_REF")
+		}
+	})
+
+	t.Run("key_prefix", func(t *testing.T) {
+		for _, k := range envVars {
+			os.Unsetenv(k)
+		}
+		os.Setenv("ACTION", "get")
+		os.Setenv("BUCKET", "b")
+		os.Setenv("KEY", "linux-yarn-abc")
+		os.Setenv("DEFAULT_KEY", "linux-yarn")
+		os.Setenv("GITHUB_REPOSITORY", "acme/widgets")
+
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Key != "acme/widgets/linux-yarn-abc.tar.zst" {
+			t.Errorf("Key = %q, want prefix from GITHUB_REPOSITORY", action.Key)
+		}
+		if action.DefaultKey != "acme/widgets/linux-yarn" || action.Prefix != "acme/widgets/linux-yarn" {
+			t.Errorf("DefaultKey = %q, Prefix = %q, want both prefixed", action.DefaultKey, action.Prefix)
+		}
+
+		os.Setenv("SCOPE", "branch")
+		os.Setenv("GITHUB_REF", "refs/heads/feature")
+		action, err = ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.storeKey() != "acme/widgets/heads/feature/linux-yarn-abc.tar.zst" {
+			t.Errorf("storeKey = %q, want key prefix outside the scope namespace", action.storeKey())
+		}
+
+		// An explicitly empty KEY_PREFIX opts out of the repository default
+		os.Unsetenv("SCOPE")
+		os.Setenv("KEY_PREFIX", "")
+		action, err = ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Key != "linux-yarn-abc.tar.zst" {
+			t.Errorf("Key = %q, want unprefixed", action.Key)
+		}
+
+		os.Setenv("KEY_PREFIX", "/team/")
+		action, err = ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Key != "team/linux-yarn-abc.tar.zst" {
+			t.Errorf("Key = %q, want %q", action.Key, "team/linux-yarn-abc.tar.zst")
+		}
+	})
+
+	t.Run("key_template", func(t *testing.T) {
+		for _, k := range envVars {
+			os.Unsetenv(k)
+		}
+		os.Setenv("ACTION", "get")
+		os.Setenv("BUCKET", "b")
+		os.Setenv("KEY", `{{ os }}-yarn-{{ env "BUCKET" }}`)
+		os.Setenv("DEFAULT_KEY", "{{ os }}-yarn-")
+
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if want := runtime.GOOS + "-yarn-b.tar.zst"; action.Key != want {
+			t.Errorf("Key = %q, want %q", action.Key, want)
+		}
+		if want := runtime.GOOS + "-yarn-"; action.DefaultKey != want {
+			t.Errorf("DefaultKey = %q, want %q", action.DefaultKey, want)
+		}
+
+		os.Setenv("KEY", "{{ os ")
+		if _, err := ParseAction(); err == nil {
+			t.Error("expected error for malformed key template")
+		}
+	})
+
+	t.Run("get_modes", func(t *testing.T) {
+		for _, k := range envVars {
+			os.Unsetenv(k)
+		}
+		os.Setenv("ACTION", "get")
+		os.Setenv("BUCKET", "b")
+		os.Setenv("KEY", "k")
+		os.Setenv("LOOKUP_ONLY", "true")
+		os.Setenv("FAIL_ON_CACHE_MISS", "1")
+
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if !action.LookupOnly || !action.FailOnCacheMiss {
+			t.Errorf("LookupOnly = %v, FailOnCacheMiss = %v, want both true", action.LookupOnly, action.FailOnCacheMiss)
+		}
+	})
+
 	t.Run("invalid_compression", func(t *testing.T) {
 		for _, k := range envVars {
 			os.Unsetenv(k)
@@ -169,6 +418,7 @@ func TestParseAction(t *testing.T) {
 		os.Setenv("ACTION", "put")
 		os.Setenv("BUCKET", "b")
 		os.Setenv("KEY", "k")
+		os.Setenv("ARTIFACTS", "node_modules")
 		os.Setenv("UPLOAD_CONCURRENCY", "20")
 		os.Setenv("DOWNLOAD_CONCURRENCY", "5")
 		os.Setenv("UPLOAD_PART_SIZE", "10MB")
@@ -238,3 +488,89 @@ func TestActionTransferConfigZeroValues(t *testing.T) {
 		t.Errorf("expected zero DownloadPartSize, got %d", tc.DownloadPartSize)
 	}
 }
+
+func TestParseArgs(t *testing.T) {
+	// t.Setenv restores every variable ParseArgs may overwrite
+	for _, k := range []string{"ACTION", "BUCKET", "KEY", "KEY_PREFIX", "ARTIFACTS", "DRY_RUN", "TRACK_ACCESS", "COMPRESSION", "STRICT"} {
+		t.Setenv(k, "")
+	}
+
+	t.Run("flag_overrides_env", func(t *testing.T) {
+		t.Setenv("ACTION", "get")
+		t.Setenv("BUCKET", "env-bucket")
+		t.Setenv("KEY", "env-key")
+		t.Setenv("ARTIFACTS", "node_modules")
+
+		if err := ParseArgs([]string{"put", "--bucket", "flag-bucket", "--dry-run"}); err != nil {
+			t.Fatalf("ParseArgs failed: %v", err)
+		}
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Action != PutAction {
+			t.Errorf("Action = %q, want command to override ACTION", action.Action)
+		}
+		if action.Bucket != "flag-bucket" {
+			t.Errorf("Bucket = %q, want flag to override BUCKET", action.Bucket)
+		}
+		if action.Key != "env-key.tar.zst" {
+			t.Errorf("Key = %q, want env fallback when flag is not given", action.Key)
+		}
+		if !action.DryRun {
+			t.Error("expected bare --dry-run to enable dry run")
+		}
+	})
+
+	t.Run("env_only", func(t *testing.T) {
+		t.Setenv("ACTION", "list")
+		t.Setenv("BUCKET", "env-bucket")
+
+		if err := ParseArgs(nil); err != nil {
+			t.Fatalf("ParseArgs failed: %v", err)
+		}
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if action.Action != ListAction || action.Bucket != "env-bucket" {
+			t.Errorf("Action = %q, Bucket = %q, want environment values", action.Action, action.Bucket)
+		}
+	})
+
+	t.Run("repeated_and_bool_flags", func(t *testing.T) {
+		t.Setenv("TRACK_ACCESS", "true")
+		t.Setenv("BUCKET", "b")
+		t.Setenv("KEY", "k")
+
+		err := ParseArgs([]string{"get", "--artifacts", "node_modules", "--artifacts=.yarn/cache", "--track-access=false"})
+		if err != nil {
+			t.Fatalf("ParseArgs failed: %v", err)
+		}
+		action, err := ParseAction()
+		if err != nil {
+			t.Fatalf("ParseAction failed: %v", err)
+		}
+		if len(action.Artifacts) != 2 || action.Artifacts[1] != ".yarn/cache" {
+			t.Errorf("Artifacts = %v, want both repeated values", action.Artifacts)
+		}
+		if action.TrackAccess {
+			t.Error("expected --track-access=false to override TRACK_ACCESS")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if err := ParseArgs([]string{"upload"}); err == nil {
+			t.Error("expected error for unknown command")
+		}
+		if err := ParseArgs([]string{"get", "--nope"}); err == nil {
+			t.Error("expected error for unknown flag")
+		}
+		if err := ParseArgs([]string{"get", "stray"}); err == nil {
+			t.Error("expected error for unexpected argument")
+		}
+		if err := ParseArgs([]string{"help"}); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("help returned %v, want flag.ErrHelp", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Object metadata keys describing where a cache archive was created
+const (
+	metadataOS      = "s3cache-os"
+	metadataCrossOS = "s3cache-cross-os"
+)
+
+// windowsReservedNames are device names Windows refuses as file names, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// archiveMetadata returns the object metadata recorded for a cache archive uploaded by a.
+func (a Action) archiveMetadata() map[string]string {
+	metadata := map[string]string{metadataOS: runtime.GOOS}
+	if a.CrossOSArchive {
+		metadata[metadataCrossOS] = "true"
+	}
+	for k, v := range a.Keyring.encryptionMetadata() {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+// crossOSProblem explains why a cache with the given object metadata cannot be restored on goos,
+// or returns "" if it can. A cache created on another OS is only restored when it was archived
+// with cross-OS support and the restore enables it too. Caches without an OS record predate
+// it and are always restored.
+func crossOSProblem(metadata map[string]string, crossOS bool, goos string) string {
+	origin, ok := metadata[metadataOS]
+	if !ok || origin == goos {
+		return ""
+	}
+	if metadata[metadataCrossOS] != "true" {
+		return fmt.Sprintf("cache was created on %s without cross-os-archive", origin)
+	}
+	if !crossOS {
+		return fmt.Sprintf("cache was created on %s and cross-os-archive is not set", origin)
+	}
+	return ""
+}
+
+// portableMode reduces a manifest entry's mode to permissions every OS can represent:
+// only whether a file is executable survives.
+func portableMode(e ManifestEntry) int64 {
+	switch {
+	case e.Type == EntryTypeDir:
+		return 0755
+	case e.Type == EntryTypeSymlink:
+		return 0777
+	case e.Mode&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+// makePortable prepares a manifest for an archive shared between operating systems. Modes are
+// normalized, and names Windows cannot create or that differ only in case are rejected, since
+// they would fail or silently overwrite each other on restore.
+func (m *Manifest) makePortable() error {
+	m.CrossOS = true
+	seen := make(map[string]string, len(m.Files))
+	for i, f := range m.Files {
+		if problem := windowsNameProblem(f.Path); problem != "" {
+			return fmt.Errorf("%s cannot be restored on Windows: %s", f.Path, problem)
+		}
+		folded := strings.ToLower(f.Path)
+		if other, ok := seen[folded]; ok && other != f.Path {
+			return fmt.Errorf("%s and %s differ only in case and would collide on Windows and macOS", other, f.Path)
+		}
+		seen[folded] = f.Path
+		m.Files[i].Mode = portableMode(f)
+	}
+	return nil
+}
+
+// windowsNameProblem explains why Windows cannot create the archived slash path name, or
+// returns "" if it can. The drive of an encoded absolute path ("/C:/...") is allowed.
+func windowsNameProblem(name string) string {
+	components := strings.Split(name, "/")
+	if len(components) > 1 && components[0] == "" && isDriveComponent(components[1]) {
+		components = components[2:]
+	}
+	for _, c := range components {
+		if c == "" || c == "." || c == ".." {
+			continue
+		}
+		if i := strings.IndexFunc(c, func(r rune) bool { return r < 32 || strings.ContainsRune(`<>:"\|?*`, r) }); i >= 0 {
+			return fmt.Sprintf("%q contains %q", c, c[i])
+		}
+		if strings.HasSuffix(c, ".") || strings.HasSuffix(c, " ") {
+			return fmt.Sprintf("%q ends with a dot or space", c)
+		}
+		base, _, _ := strings.Cut(c, ".")
+		if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			return fmt.Sprintf("%q is a reserved device name", c)
+		}
+	}
+	return ""
+}
+
+// isDriveComponent reports whether c is a Windows drive such as "C:".
+func isDriveComponent(c string) bool {
+	return len(c) == 2 && c[1] == ':' && (c[0] >= 'A' && c[0] <= 'Z' || c[0] >= 'a' && c[0] <= 'z')
+}
+
+// caseInsensitiveFS reports whether the default file system of this OS ignores case.
+func caseInsensitiveFS() bool {
+	return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
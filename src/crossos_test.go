@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCrossOSProblem(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		crossOS  bool
+		ok       bool
+	}{
+		{"same os", map[string]string{metadataOS: "linux"}, false, true},
+		{"no os record", map[string]string{}, false, true},
+		{"other os", map[string]string{metadataOS: "windows"}, true, false},
+		{"portable archive, restore not enabled", map[string]string{metadataOS: "darwin", metadataCrossOS: "true"}, false, false},
+		{"portable archive, restore enabled", map[string]string{metadataOS: "darwin", metadataCrossOS: "true"}, true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			problem := crossOSProblem(tc.metadata, tc.crossOS, "linux")
+			if (problem == "") != tc.ok {
+				t.Errorf("expected ok=%v, got %q", tc.ok, problem)
+			}
+		})
+	}
+}
+
+func TestArchiveMetadata(t *testing.T) {
+	md := Action{CrossOSArchive: true}.archiveMetadata()
+	if md[metadataOS] == "" || md[metadataCrossOS] != "true" {
+		t.Errorf("unexpected metadata %v", md)
+	}
+	if _, ok := (Action{}).archiveMetadata()[metadataCrossOS]; ok {
+		t.Error("cross-os must only be recorded when enabled")
+	}
+}
+
+func TestWindowsNameProblem(t *testing.T) {
+	valid := []string{"node_modules/.bin/tsc", "~/.m2/repository", "/C:/tools/bin", "../sibling/x", "console/file", "a.b.c"}
+	for _, name := range valid {
+		if problem := windowsNameProblem(name); problem != "" {
+			t.Errorf("expected %q to be valid, got %q", name, problem)
+		}
+	}
+	invalid := []string{"dir/a:b", "dir/what?", `dir/back\slash`, "dir/trailing.", "dir/trailing ", "aux.js", "src/COM1", "lpt9.txt/x"}
+	for _, name := range invalid {
+		if windowsNameProblem(name) == "" {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestMakePortable(t *testing.T) {
+	m := &Manifest{Files: []ManifestEntry{
+		{Path: "bin", Type: EntryTypeDir, Mode: 0700},
+		{Path: "bin/run", Type: EntryTypeFile, Mode: 0700},
+		{Path: "bin/data", Type: EntryTypeFile, Mode: 0600},
+	}}
+	if err := m.makePortable(); err != nil {
+		t.Fatalf("makePortable failed: %v", err)
+	}
+	if !m.CrossOS || m.Files[0].Mode != 0755 || m.Files[1].Mode != 0755 || m.Files[2].Mode != 0644 {
+		t.Errorf("unexpected modes %+v", m.Files)
+	}
+
+	m.Files = append(m.Files, ManifestEntry{Path: "bin/Run", Type: EntryTypeFile})
+	if err := m.makePortable(); err == nil || !strings.Contains(err.Error(), "differ only in case") {
+		t.Errorf("expected a case collision error, got %v", err)
+	}
+}
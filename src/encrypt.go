@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted archives use a chunked AES-256-GCM construction (the STREAM scheme):
+//
+//	magic(8) | key id length(1) | key id | nonce prefix(7) | chunk...
+//
+// Each chunk holds up to encryptChunkSize bytes of plaintext followed by a 16 byte tag.
+// A chunk's nonce is the nonce prefix, a 4 byte big-endian chunk counter and a final flag
+// byte, so chunks cannot be reordered, dropped or truncated without failing authentication.
+const (
+	// EncryptionAES256GCM - Client-side encryption algorithm recorded in object metadata
+	EncryptionAES256GCM = "aes-256-gcm"
+
+	// Object metadata keys describing client-side encryption
+	metadataEncryption = "s3cache-encryption"
+	metadataKeyID      = "s3cache-key-id"
+
+	encryptMagic       = "S3CENC01"
+	encryptKeySize     = 32
+	encryptNoncePrefix = 7
+	encryptChunkSize   = 64 * 1024
+)
+
+type (
+	// EncryptionKey - An AES-256 key and its identifier
+	EncryptionKey struct {
+		ID  string
+		key []byte
+	}
+
+	// Keyring - Keys available for client-side encryption.
+	// The first key encrypts new caches; every key can decrypt.
+	Keyring struct {
+		keys []EncryptionKey
+	}
+)
+
+// ParseKeyring builds a keyring from an inline key and a key file.
+// Keys are 32 bytes encoded as base64 or hex. The key file holds one key per line,
+// optionally written as "<id>:<key>"; blank lines and lines starting with # are ignored.
+// Keys without an explicit id are identified by a fingerprint of the key material.
+// Returns nil if neither source is set.
+func ParseKeyring(inline string, path string) (*Keyring, error) {
+	var lines []string
+	if inline = strings.TrimSpace(inline); inline != "" {
+		lines = append(lines, inline)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+
+	kr := &Keyring{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := parseEncryptionKey(line)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys = append(kr.keys, k)
+	}
+	if len(kr.keys) == 0 {
+		return nil, nil
+	}
+	return kr, nil
+}
+
+// parseEncryptionKey decodes a single "[id:]key" entry.
+func parseEncryptionKey(s string) (EncryptionKey, error) {
+	id, encoded, hasID := strings.Cut(s, ":")
+	if !hasID {
+		id, encoded = "", s
+	}
+
+	raw, err := hex.DecodeString(encoded)
+	if err != nil {
+		raw, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(raw) != encryptKeySize {
+		return EncryptionKey{}, fmt.Errorf("invalid encryption key: must be %d bytes encoded as hex or base64", encryptKeySize)
+	}
+
+	if id == "" {
+		sum := sha256.Sum256(raw)
+		id = hex.EncodeToString(sum[:8])
+	}
+	if len(id) > 255 {
+		return EncryptionKey{}, fmt.Errorf("encryption key id %q is too long", id)
+	}
+	return EncryptionKey{ID: id, key: raw}, nil
+}
+
+// Primary returns the key used to encrypt new caches.
+func (kr *Keyring) Primary() EncryptionKey {
+	return kr.keys[0]
+}
+
+// lookup returns the key with the given id.
+func (kr *Keyring) lookup(id string) (EncryptionKey, bool) {
+	if kr == nil {
+		return EncryptionKey{}, false
+	}
+	for _, k := range kr.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return EncryptionKey{}, false
+}
+
+// encryptionMetadata returns the object metadata recorded for caches encrypted with the keyring.
+func (kr *Keyring) encryptionMetadata() map[string]string {
+	if kr == nil {
+		return nil
+	}
+	return map[string]string{
+		metadataEncryption: EncryptionAES256GCM,
+		metadataKeyID:      kr.Primary().ID,
+	}
+}
+
+// ErrNoDecryptionKey is returned when an archive is encrypted with a key that is not configured.
+var ErrNoDecryptionKey = errors.New("no configured key can decrypt this cache")
+
+// ErrUnencryptedCache is returned when a keyring is configured but an archive is not encrypted.
+// Anyone able to write to the bucket could otherwise plant a plaintext cache.
+var ErrUnencryptedCache = errors.New("cache is not encrypted")
+
+func newChunkCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds the nonce for a chunk from the stream's prefix, counter and final flag.
+func chunkNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 0, encryptNoncePrefix+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptWriter seals plaintext into authenticated chunks.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// newEncryptWriter writes the stream header to w and returns a writer that encrypts into it.
+// Close MUST be called to write the final chunk; it does not close w.
+func newEncryptWriter(w io.Writer, k EncryptionKey) (io.WriteCloser, error) {
+	aead, err := newChunkCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(encryptMagic)+1+len(k.ID)+encryptNoncePrefix)
+	header = append(header, encryptMagic...)
+	header = append(header, byte(len(k.ID)))
+	header = append(header, k.ID...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the last chunk
+		// is always sealed by Close with the final flag set.
+		if len(e.buf) == encryptChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("encrypted stream too large")
+	}
+	out := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, final), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+// decryptReader opens authenticated chunks written by encryptWriter.
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+// isEncrypted reports whether the stream starts with the encrypted archive header.
+func isEncrypted(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(encryptMagic))
+	return err == nil && bytes.Equal(magic, []byte(encryptMagic))
+}
+
+// newDecryptReader reads the stream header from r and returns a reader over the plaintext.
+func newDecryptReader(r *bufio.Reader, kr *Keyring) (io.Reader, error) {
+	header := make([]byte, len(encryptMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	id := make([]byte, header[len(encryptMagic)])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	prefix := make([]byte, encryptNoncePrefix)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+
+	k, ok := kr.lookup(string(id))
+	if !ok {
+		return nil, fmt.Errorf("%w (key id %q)", ErrNoDecryptionKey, id)
+	}
+	aead, err := newChunkCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		prefix: prefix,
+		chunk:  make([]byte, encryptChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return errors.New("encrypted stream truncated")
+		}
+		return err
+	}
+	// The final chunk is the one not followed by any more data
+	_, peekErr := d.r.Peek(1)
+	final := peekErr == io.EOF
+
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.prefix, d.counter, final), d.chunk[:n], nil)
+	if err != nil {
+		return errors.New("failed to decrypt cache: authentication failed")
+	}
+	d.counter++
+	d.plain = plain
+	d.done = final
+	return nil
+}
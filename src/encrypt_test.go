@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyring(t *testing.T, id string) *Keyring {
+	t.Helper()
+	raw := make([]byte, encryptKeySize)
+	rand.Read(raw)
+	kr, err := ParseKeyring(id+":"+hex.EncodeToString(raw), "")
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	return kr
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	sizes := []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3*encryptChunkSize + 17}
+
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var buf bytes.Buffer
+		ew, err := newEncryptWriter(&buf, kr.Primary())
+		if err != nil {
+			t.Fatalf("newEncryptWriter failed: %v", err)
+		}
+		if _, err := ew.Write(plain); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := ew.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		br := bufio.NewReader(&buf)
+		if !isEncrypted(br) {
+			t.Fatalf("size %d: stream not detected as encrypted", size)
+		}
+		dr, err := newDecryptReader(br, kr)
+		if err != nil {
+			t.Fatalf("size %d: newDecryptReader failed: %v", size, err)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil {
+			t.Fatalf("size %d: decrypt failed: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+	}
+}
+
+func TestDecryptRejectsTamperingAndTruncation(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	plain := make([]byte, 2*encryptChunkSize+100)
+	rand.Read(plain)
+
+	var buf bytes.Buffer
+	ew, _ := newEncryptWriter(&buf, kr.Primary())
+	ew.Write(plain)
+	ew.Close()
+	sealed := buf.Bytes()
+
+	decrypt := func(data []byte) error {
+		dr, err := newDecryptReader(bufio.NewReader(bytes.NewReader(data)), kr)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(dr)
+		return err
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)/2] ^= 0xff
+	if err := decrypt(tampered); err == nil {
+		t.Error("expected tampered stream to fail authentication")
+	}
+
+	// Drop the final chunk: the previous chunk was sealed as non-final
+	chunk := encryptChunkSize + 16
+	headerLen := len(encryptMagic) + 1 + len("k1") + encryptNoncePrefix
+	if err := decrypt(sealed[:headerLen+2*chunk]); err == nil {
+		t.Error("expected truncated stream to fail authentication")
+	}
+
+	other := testKeyring(t, "k2")
+	dr, err := newDecryptReader(bufio.NewReader(bytes.NewReader(sealed)), other)
+	if !errors.Is(err, ErrNoDecryptionKey) || dr != nil {
+		t.Errorf("expected ErrNoDecryptionKey, got %v", err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	raw := bytes.Repeat([]byte{0x42}, encryptKeySize)
+	hexKey := hex.EncodeToString(raw)
+
+	kr, err := ParseKeyring("", "")
+	if err != nil || kr != nil {
+		t.Fatalf("expected nil keyring when unset, got %v, %v", kr, err)
+	}
+
+	if _, err := ParseKeyring("deadbeef", ""); err == nil {
+		t.Error("expected error for short key")
+	}
+
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "keys")
+	content := "# rotated keys\nnew:" + hexKey + "\n\nQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkI=\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	kr, err = ParseKeyring("", path)
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	if kr.Primary().ID != "new" {
+		t.Errorf("primary key id = %q, want %q", kr.Primary().ID, "new")
+	}
+	if len(kr.keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(kr.keys))
+	}
+	// base64 key without id gets a fingerprint id
+	if id := kr.keys[1].ID; len(id) != 16 {
+		t.Errorf("fingerprint id = %q, want 16 hex chars", id)
+	}
+	if md := kr.encryptionMetadata(); md[metadataKeyID] != "new" || md[metadataEncryption] != EncryptionAES256GCM {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+}
+
+func TestZipAndUnzipEncrypted(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("secret", 0755)
+	os.WriteFile("secret/token.txt", []byte("do not leak"), 0600)
+
+	kr := testKeyring(t, "k1")
+	ac := ArchiveConfig{Compression: CompressionZstd, Keyring: kr}
+	if err := ZipArchive("secret.tar.zst", []string{"secret"}, ac); err != nil {
+		t.Fatalf("ZipArchive failed: %v", err)
+	}
+
+	data, _ := os.ReadFile("secret.tar.zst")
+	if !bytes.HasPrefix(data, []byte(encryptMagic)) {
+		t.Fatal("archive is not encrypted")
+	}
+
+	os.RemoveAll("secret")
+	if err := Unzip("secret.tar.zst", CompressionZstd); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Fatalf("expected ErrNoDecryptionKey without keyring, got %v", err)
+	}
+	if _, err := UnzipArchive("secret.tar.zst", ac); err != nil {
+		t.Fatalf("UnzipArchive failed: %v", err)
+	}
+	content, err := os.ReadFile("secret/token.txt")
+	if err != nil || string(content) != "do not leak" {
+		t.Errorf("unexpected extracted content %q: %v", content, err)
+	}
+}
+
+func TestUnzipRefusesUnencryptedWithKeyring(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("planted", 0755)
+	os.WriteFile("planted/tool.sh", []byte("echo pwned"), 0755)
+	if err := Zip("plain.tar.zst", []string{"planted"}, CompressionZstd, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	os.RemoveAll("planted")
+
+	ac := ArchiveConfig{Compression: CompressionZstd, Keyring: testKeyring(t, "k1")}
+	if _, err := UnzipArchive("plain.tar.zst", ac); !errors.Is(err, ErrUnencryptedCache) {
+		t.Fatalf("expected ErrUnencryptedCache, got %v", err)
+	}
+	if _, err := os.Stat("planted"); !os.IsNotExist(err) {
+		t.Error("nothing should be extracted from a refused cache")
+	}
+
+	ac.AllowUnencrypted = true
+	if _, err := UnzipArchive("plain.tar.zst", ac); err != nil {
+		t.Fatalf("UnzipArchive with allow-unencrypted failed: %v", err)
+	}
+	if _, err := os.Stat("planted/tool.sh"); err != nil {
+		t.Errorf("expected the plaintext cache restored with allow-unencrypted: %v", err)
+	}
+}
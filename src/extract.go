@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type (
+	// extractPool - Writes buffered archive files on a bounded pool of workers while the
+	// tar stream is read on the calling goroutine. At most readAheadWindow files per worker
+	// wait to be written, each no larger than readAheadFileSize.
+	extractPool struct {
+		jobs    chan extractJob
+		verify  func(name string, sum string) error
+		pending sync.WaitGroup // submitted files not written yet
+		workers sync.WaitGroup
+		once    sync.Once
+
+		mu  sync.Mutex
+		err error // first failure
+	}
+
+	// linkGuard - The symlinks created by one extraction. No later entry may be written
+	// through or onto one of them, so an archive cannot place files outside its targets.
+	linkGuard map[string]bool
+
+	// extractJob - A regular file read from the archive, waiting to be written
+	extractJob struct {
+		target string
+		header *tar.Header
+		data   []byte
+	}
+)
+
+// newExtractPool starts the workers. verify checks a written file's hash against the manifest.
+func newExtractPool(workers int, verify func(name string, sum string) error) *extractPool {
+	if workers <= 0 {
+		workers = defaultConcurrency
+	}
+	workers = min(workers, maxConcurrency)
+	p := &extractPool{
+		jobs:   make(chan extractJob, workers*readAheadWindow),
+		verify: verify,
+	}
+	for range workers {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				// After a failure the remaining files are only drained
+				if p.failed() == nil {
+					p.fail(writeExtractedFile(job.target, job.header, bytes.NewReader(job.data), p.verify))
+				}
+				p.pending.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues a file, blocking while the queue is full.
+func (p *extractPool) submit(job extractJob) {
+	p.pending.Add(1)
+	p.jobs <- job
+}
+
+// wait blocks until every submitted file is written and returns the first failure.
+func (p *extractPool) wait() error {
+	p.pending.Wait()
+	return p.failed()
+}
+
+// close stops the workers once the queue is drained and returns the first failure.
+func (p *extractPool) close() error {
+	p.once.Do(func() { close(p.jobs) })
+	p.workers.Wait()
+	return p.failed()
+}
+
+func (p *extractPool) fail(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *extractPool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// writeExtractedFile creates the file's directory, writes it and checks its hash.
+func writeExtractedFile(target string, header *tar.Header, r io.Reader, verify func(string, string) error) error {
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	sum, err := extractFile(target, header, r)
+	if err != nil {
+		return err
+	}
+	return verify(header.Name, sum)
+}
+
+// check refuses target if it or one of its parent directories is a symlink created by
+// this extraction. Links themselves may replace an earlier link at the same path.
+func (g linkGuard) check(target string, header *tar.Header) error {
+	dir := filepath.Clean(target)
+	if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		if g[dir] {
+			return fmt.Errorf("archived path %s would be written through the symlink %s", header.Name, dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// extractLink creates a symlink or hard link, replacing whatever is at target.
+// Hard link targets are archived names, so they are mapped like the entry's own name.
+// With a restore root, symlinks must be relative and resolve inside it.
+func extractLink(ac ArchiveConfig, target string, header *tar.Header) error {
+	if header.Typeflag == tar.TypeSymlink && ac.RestoreRoot != "" {
+		if err := checkSymlinkTarget(ac.RestoreRoot, target, header); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return fmt.Errorf("failed creating symlink %s: %w", target, err)
+		}
+		return nil
+	}
+	oldname, err := ac.extractTarget(header.Linkname)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(oldname, target); err != nil {
+		return fmt.Errorf("failed creating hard link %s: %w", target, err)
+	}
+	return nil
+}
+
+// checkSymlinkTarget refuses a symlink at target whose link resolves outside root.
+func checkSymlinkTarget(root string, target string, header *tar.Header) error {
+	linkname := filepath.FromSlash(header.Linkname)
+	if filepath.IsAbs(linkname) || strings.HasPrefix(header.Linkname, "/") || filepath.VolumeName(linkname) != "" {
+		return fmt.Errorf("symlink %s points to the absolute path %s outside the restore root", header.Name, header.Linkname)
+	}
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(target), linkname))
+	if err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("symlink %s points to %s outside the restore root", header.Name, header.Linkname)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnzipArchiveParallelLinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar")
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(out)
+	addFile := func(name string, data string) {
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write([]byte(data))
+	}
+	for i := range 50 {
+		addFile(fmt.Sprintf("pkg/f%02d.txt", i), strings.Repeat("x", i))
+	}
+	addFile("pkg/big.bin", strings.Repeat("b", readAheadFileSize+1))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "empty", Mode: 0755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "pkg/hard.txt", Linkname: "pkg/f49.txt"})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "current", Linkname: "pkg"})
+	addFile("pkg/late.txt", "late")
+	tw.Close()
+	out.Close()
+
+	root := filepath.Join(dir, "restored")
+	stats, err := UnzipArchive(archive, ArchiveConfig{Compression: CompressionNone, RestoreRoot: root, ExtractConcurrency: 4})
+	if err != nil {
+		t.Fatalf("UnzipArchive failed: %v", err)
+	}
+	if stats.Files != 52 {
+		t.Errorf("expected 52 files, got %d", stats.Files)
+	}
+
+	for name, want := range map[string]string{
+		"pkg/f07.txt":  "xxxxxxx",
+		"pkg/hard.txt": strings.Repeat("x", 49),
+		"pkg/late.txt": "late",
+	} {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: got %q, %v", name, data, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(root, "pkg", "big.bin")); err != nil || info.Size() != readAheadFileSize+1 {
+		t.Errorf("large file not extracted: %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(root, "current")); err != nil || link != "pkg" {
+		t.Errorf("expected symlink to pkg, got %q, %v", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("expected empty directory, got %v", err)
+	}
+}
+
+func TestUnzipArchiveParallelChecksum(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("many", 0755)
+	for i := range 100 {
+		os.WriteFile(filepath.Join("many", fmt.Sprintf("%03d", i)), []byte(fmt.Sprint(i)), 0644)
+	}
+	if err := Zip("many.tar", []string{"many"}, CompressionNone, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+
+	// Corrupt one file's contents in place, keeping the archive size the same
+	data, _ := os.ReadFile("many.tar")
+	corrupted := false
+	for i := 0; i+3 <= len(data); i += 512 {
+		if string(data[i:i+3]) == "42\x00" {
+			data[i], corrupted = '7', true
+		}
+	}
+	if !corrupted {
+		t.Fatal("file contents not found in archive")
+	}
+	os.WriteFile("many.tar", data, 0644)
+
+	_, err := UnzipArchive("many.tar", ArchiveConfig{Compression: CompressionNone, ExtractConcurrency: 8})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch from a worker, got %v", err)
+	}
+}
+
+// writeTestTar writes a plain tar of the given headers, with data for regular files.
+func writeTestTar(t *testing.T, path string, headers ...*tar.Header) {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+	for _, h := range headers {
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(h.Name))
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			tw.Write([]byte(h.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnzipArchiveSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"absolute link", []*tar.Header{
+			{Typeflag: tar.TypeSymlink, Name: "p/link", Linkname: outside},
+		}},
+		{"root link then file", []*tar.Header{
+			{Typeflag: tar.TypeSymlink, Name: "x", Linkname: "/"},
+			{Typeflag: tar.TypeReg, Name: "x" + outside + "/escaped", Mode: 0644},
+		}},
+		{"relative link out of the root", []*tar.Header{
+			{Typeflag: tar.TypeSymlink, Name: "a/up", Linkname: "../.."},
+		}},
+		{"file through an in-root link", []*tar.Header{
+			{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "."},
+			{Typeflag: tar.TypeReg, Name: "dir/link/f", Mode: 0644},
+		}},
+		{"file onto a link", []*tar.Header{
+			{Typeflag: tar.TypeSymlink, Name: "target", Linkname: "other"},
+			{Typeflag: tar.TypeReg, Name: "target", Mode: 0644},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "evil.tar")
+			writeTestTar(t, archive, tc.headers...)
+
+			root := filepath.Join(dir, "root")
+			if _, err := UnzipArchive(archive, ArchiveConfig{Compression: CompressionNone, RestoreRoot: root}); err == nil {
+				t.Error("expected the archive to be refused")
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+				t.Errorf("archive wrote outside the restore root: %v", entries)
+			}
+		})
+	}
+}
+
+func TestUnzipArchiveNoWriteThroughLink(t *testing.T) {
+	// Without a restore root links may point anywhere, but nothing is written through them
+	dir, outside := t.TempDir(), t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	writeTestTar(t, "evil.tar",
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: outside},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "link/escaped", Mode: 0644},
+	)
+	_, err := UnzipArchive("evil.tar", ArchiveConfig{Compression: CompressionNone})
+	if err == nil || !strings.Contains(err.Error(), "through the symlink") {
+		t.Errorf("expected the write through the link to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("file was written through the link: %v", err)
+	}
+}
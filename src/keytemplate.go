@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// keyTemplater evaluates key templates such as
+//
+//	{{ os }}-{{ arch }}-{{ hashFiles "**/yarn.lock" }}-{{ env "NODE_VERSION" }}
+//
+// hashFiles results are memoized, so several keys hashing the same patterns walk the tree once.
+type keyTemplater struct {
+	hashes map[string]string
+}
+
+func newKeyTemplater() *keyTemplater {
+	return &keyTemplater{hashes: make(map[string]string)}
+}
+
+// expand evaluates s as a key template. Strings without "{{" are returned unchanged.
+func (kt *keyTemplater) expand(name string, s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"os":        func() string { return runtime.GOOS },
+		"arch":      func() string { return runtime.GOARCH },
+		"env":       os.Getenv,
+		"hashFiles": kt.hashFiles,
+	}).Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid key template: %w", name, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", fmt.Errorf("%s: failed to evaluate key template: %w", name, err)
+	}
+	slog.Info("resolved key template", "var", name, "template", s, "key", b.String())
+	return b.String(), nil
+}
+
+// hashFiles returns a single SHA-256 over the paths and contents of every file matching the
+// patterns, relative to the working directory, like the GitHub Actions function of the same name
+// but also covering the paths. Patterns support "**" and are negated with a leading "!".
+// Returns an empty string if no file matches.
+func (kt *keyTemplater) hashFiles(patterns ...string) (string, error) {
+	memoKey := strings.Join(patterns, "\x00")
+	if h, ok := kt.hashes[memoKey]; ok {
+		return h, nil
+	}
+
+	files, err := matchFiles(patterns)
+	if err != nil {
+		return "", err
+	}
+	h, err := hashFileList(files)
+	if err != nil {
+		return "", err
+	}
+	kt.hashes[memoKey] = h
+	return h, nil
+}
+
+// matchFiles returns the sorted slash-separated paths of regular files matched by the last
+// applicable pattern. Only the fixed leading directories of the patterns are walked, and .git
+// directories, as well as directories excluded by a trailing "!dir/**" pattern, are skipped.
+func matchFiles(patterns []string) ([]string, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(strings.ReplaceAll(strings.TrimPrefix(p, "!"), "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	var files []string
+	for _, root := range walkRoots(patterns) {
+		err := filepath.WalkDir(filepath.FromSlash(root), func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// A pattern naming a path that does not exist matches nothing
+				if file == filepath.FromSlash(root) && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			rel := filepath.ToSlash(file)
+			if d.IsDir() {
+				if d.Name() == ".git" || excludedDir(patterns, rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			matched := false
+			for _, p := range patterns {
+				if negated, ok := strings.CutPrefix(p, "!"); ok {
+					if matchGlob(negated, rel) {
+						matched = false
+					}
+				} else if matchGlob(p, rel) {
+					matched = true
+				}
+			}
+			if matched {
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// walkRoots returns the fixed leading directories of the positive patterns, the part before
+// the first segment with a wildcard, leaving out roots inside another root.
+// "packages/*/package.json" is walked from "packages", "**/yarn.lock" from ".".
+func walkRoots(patterns []string) []string {
+	var roots []string
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			continue
+		}
+		segments := strings.Split(path.Clean(p), "/")
+		fixed := 0
+		for fixed < len(segments) && !strings.ContainsAny(segments[fixed], `*?[\`) {
+			fixed++
+		}
+		root := strings.Join(segments[:fixed], "/")
+		if root == "" && strings.HasPrefix(p, "/") {
+			root = "/"
+		} else if root == "" {
+			root = "."
+		}
+		roots = append(roots, root)
+	}
+
+	sort.Strings(roots)
+	var unique []string
+	for _, root := range roots {
+		if !slices.ContainsFunc(unique, func(parent string) bool { return pathWithinRoot(parent, root) }) {
+			unique = append(unique, root)
+		}
+	}
+	return unique
+}
+
+// pathWithinRoot reports whether the slash path name is root or lies below it.
+func pathWithinRoot(root string, name string) bool {
+	switch {
+	case root == name:
+		return true
+	case root == ".":
+		return !strings.HasPrefix(name, "/") && name != ".." && !strings.HasPrefix(name, "../")
+	case root == "/":
+		return strings.HasPrefix(name, "/")
+	}
+	return strings.HasPrefix(name, root+"/")
+}
+
+// excludedDir reports whether everything below the slash path dir is excluded: a negated
+// "dir/**" pattern matches it and no positive pattern after that can match anything again.
+func excludedDir(patterns []string, dir string) bool {
+	for i, p := range patterns {
+		negated, ok := strings.CutPrefix(p, "!")
+		if !ok {
+			continue
+		}
+		prefix, ok := strings.CutSuffix(path.Clean(negated), "/**")
+		if !ok || !matchGlob(prefix, dir) {
+			continue
+		}
+		if !slices.ContainsFunc(patterns[i+1:], func(later string) bool { return !strings.HasPrefix(later, "!") }) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether the slash-separated name matches pattern, where a "**" segment
+// matches any number of path segments and other segments follow path.Match.
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(path.Clean(pattern), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every possible number of segments for the wildcard
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// hashFileList hashes files in parallel and combines each path with its digest in list order,
+// so the result only depends on the paths and their contents: renaming or moving a file
+// changes it just like editing one.
+func hashFileList(files []string) (string, error) {
+	if len(files) == 0 {
+		return "", nil
+	}
+
+	sums := make([]string, len(files))
+	errs := make([]error, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.NumCPU(), len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sums[i], errs[i] = hashFile(files[i])
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	combined := sha256.New()
+	for i, sum := range sums {
+		if errs[i] != nil {
+			return "", fmt.Errorf("failed to hash %s: %w", files[i], errs[i])
+		}
+		// Paths cannot contain NUL, so path and digest boundaries are unambiguous
+		raw, _ := hex.DecodeString(sum)
+		combined.Write([]byte(files[i] + "\x00"))
+		combined.Write(raw)
+	}
+	return hex.EncodeToString(combined.Sum(nil)), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"**/yarn.lock", "yarn.lock", true},
+		{"**/yarn.lock", "packages/app/yarn.lock", true},
+		{"packages/*/package.json", "packages/app/package.json", true},
+		{"packages/*/package.json", "packages/app/sub/package.json", false},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/c.go", true},
+		{"./go.sum", "go.sum", true},
+		{"*.lock", "sub/yarn.lock", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("app/node_modules/dep", 0755)
+	os.WriteFile("yarn.lock", []byte("root"), 0644)
+	os.WriteFile("app/yarn.lock", []byte("app"), 0644)
+	os.WriteFile("app/node_modules/dep/yarn.lock", []byte("dep"), 0644)
+
+	kt := newKeyTemplater()
+	all, err := kt.hashFiles("**/yarn.lock")
+	if err != nil {
+		t.Fatalf("hashFiles failed: %v", err)
+	}
+	if len(all) != 64 {
+		t.Fatalf("hashFiles = %q, want hex SHA-256", all)
+	}
+	again, _ := newKeyTemplater().hashFiles("**/yarn.lock")
+	if again != all {
+		t.Error("hashFiles is not deterministic")
+	}
+
+	excluded, err := kt.hashFiles("**/yarn.lock", "!**/node_modules/**")
+	if err != nil {
+		t.Fatalf("hashFiles failed: %v", err)
+	}
+	if excluded == all {
+		t.Error("negated pattern should exclude node_modules")
+	}
+
+	os.WriteFile("app/yarn.lock", []byte("changed"), 0644)
+	if changed, _ := newKeyTemplater().hashFiles("**/yarn.lock"); changed == all {
+		t.Error("hash should change with file contents")
+	}
+
+	// Paths are hashed with the contents, so moving a file changes the key
+	before, _ := newKeyTemplater().hashFiles("**/yarn.lock", "!**/node_modules/**")
+	os.MkdirAll("web", 0755)
+	os.Rename("app/yarn.lock", "web/yarn.lock")
+	if moved, _ := newKeyTemplater().hashFiles("**/yarn.lock", "!**/node_modules/**"); moved == before {
+		t.Error("hash should change when a file moves")
+	}
+
+	if none, _ := kt.hashFiles("**/missing.lock"); none != "" {
+		t.Errorf("hashFiles with no matches = %q, want empty", none)
+	}
+	if _, err := kt.hashFiles("[bad"); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
+
+func TestWalkRoots(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{[]string{"**/yarn.lock"}, []string{"."}},
+		{[]string{"packages/*/package.json", "packages/app/yarn.lock"}, []string{"packages"}},
+		{[]string{"go.sum", "tools/**/go.sum", "!tools/vendor/**"}, []string{"go.sum", "tools"}},
+		{[]string{"src/**/*.go", "**/go.mod"}, []string{"."}},
+		{[]string{"./go.sum", "/opt/cache/*.lock"}, []string{"/opt/cache", "go.sum"}},
+		{[]string{"../shared/*.lock"}, []string{"../shared"}},
+	}
+	for _, tt := range tests {
+		if got := walkRoots(tt.patterns); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("walkRoots(%q) = %q, want %q", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestExcludedDir(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		dir      string
+		want     bool
+	}{
+		{[]string{"**/yarn.lock", "!**/node_modules/**"}, "app/node_modules", true},
+		{[]string{"**/yarn.lock", "!**/node_modules/**"}, "app", false},
+		// A later positive pattern may match inside the directory again
+		{[]string{"**/yarn.lock", "!**/node_modules/**", "**/node_modules/keep/yarn.lock"}, "app/node_modules", false},
+		{[]string{"**/yarn.lock", "!**/node_modules/*.lock"}, "app/node_modules", false},
+	}
+	for _, tt := range tests {
+		if got := excludedDir(tt.patterns, tt.dir); got != tt.want {
+			t.Errorf("excludedDir(%q, %q) = %v, want %v", tt.patterns, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestExpandKeyTemplate(t *testing.T) {
+	t.Setenv("NODE_VERSION", "20")
+	kt := newKeyTemplater()
+
+	got, err := kt.expand("KEY", `{{ os }}-{{ arch }}-node{{ env "NODE_VERSION" }}`)
+	if err != nil {
+		t.Fatalf("expand failed: %v", err)
+	}
+	if want := runtime.GOOS + "-" + runtime.GOARCH + "-node20"; got != want {
+		t.Errorf("expand = %q, want %q", got, want)
+	}
+
+	if got, _ := kt.expand("KEY", "plain-key"); got != "plain-key" {
+		t.Errorf("plain key changed to %q", got)
+	}
+	if _, err := kt.expand("KEY", "{{ nope }}"); err == nil {
+		t.Error("expected error for unknown function")
+	}
+}
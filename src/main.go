@@ -2,13 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"runtime"
+	"text/tabwriter"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
 )
 
 func main() {
+	if err := ParseArgs(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	InitLogger()
 
 	action, err := ParseAction()
@@ -17,61 +33,128 @@ func main() {
 		os.Exit(1)
 	}
 
+	SetServerSideEncryption(action.SSE)
+	SetProgressInterval(action.ProgressInterval)
+
 	tc := action.TransferConfig()
 	slog.Info("configuration",
 		"compression", action.Compression,
 		"compression_level", action.CompressionLevel,
 		"upload_concurrency", tc.uploadConcurrency(),
 		"download_concurrency", tc.downloadConcurrency(),
+		"sse", action.SSE.describe(),
 	)
 
+	// The whole run is one trace; it and the metrics are exported even when the action fails
+	ctx, span := StartSpan(context.Background(), action.Action, "bucket", action.Bucket)
+	err = run(ctx, action, tc)
+	span.SetError(err)
+	span.End()
+	ExportTelemetry(action.Action, action.Telemetry)
+	ExportMetrics(action.Metrics)
+
+	if err != nil {
+		slog.Error(action.Action+" failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run performs the configured action.
+func run(ctx context.Context, action Action, tc TransferConfig) error {
+	if len(action.Caches) > 0 {
+		return runCaches(ctx, action, tc)
+	}
+
 	switch action.Action {
 	case PutAction:
-		if err := runPut(action, tc); err != nil {
-			slog.Error("put failed", "error", err)
-			os.Exit(1)
-		}
+		return runPut(ctx, action, tc)
 	case GetAction:
-		if err := runGet(action, tc); err != nil {
-			slog.Error("get failed", "error", err)
-			os.Exit(1)
-		}
+		return runGet(ctx, action, tc)
 	case DeleteAction:
-		if err := runDelete(action); err != nil {
-			slog.Error("delete failed", "error", err)
-			os.Exit(1)
-		}
+		return runDelete(action)
+	case ListAction:
+		return runList(action)
+	case InspectAction:
+		return runInspect(action)
+	case PruneAction:
+		return runPrune(action)
 	default:
-		slog.Error("invalid action", "action", action.Action, "valid_options", []string{PutAction, DeleteAction, GetAction})
-		os.Exit(1)
+		return fmt.Errorf("invalid action %q, valid options: %v", action.Action,
+			[]string{PutAction, DeleteAction, GetAction, ListAction, InspectAction, PruneAction})
 	}
 }
 
-func runPut(action Action, tc TransferConfig) error {
+func runPut(ctx context.Context, action Action, tc TransferConfig) error {
+	report := CacheReport{}
+	err := saveCache(ctx, action, tc, &report)
+	WriteStepSummary(PutAction, []CacheReport{report})
+	recordMetrics(action, []CacheReport{report})
+	return err
+}
+
+// saveCache archives and uploads the artifacts unless the key already exists.
+// Each phase is recorded as a span under the span in ctx.
+func saveCache(ctx context.Context, action Action, tc TransferConfig, report *CacheReport) error {
 	if len(action.Artifacts) == 0 || len(action.Artifacts[0]) == 0 {
 		return fmt.Errorf("no artifacts patterns provided")
 	}
 
-	shouldSkip, err := ObjectExists(action.Key, action.Bucket)
+	key := action.storeKey()
+	report.Key = key
+	report.Prefix = metricsPrefix(action)
+	listStart := time.Now()
+	defer func() { report.Timings.Total = time.Since(listStart) }()
+	_, lookup := StartSpan(ctx, SpanLookup, "key", key)
+	shouldSkip, err := ObjectExists(key, action.Bucket)
+	lookup.SetError(err)
+	lookup.Set("exists", shouldSkip)
+	lookup.End()
+	report.Timings.List = time.Since(listStart)
 	if err != nil {
 		return fmt.Errorf("failed to check if object exists: %w", err)
 	}
 	if shouldSkip {
+		report.Outcome = OutcomeExists
 		slog.Info("cache hit, skipping cache upload")
 		return nil
 	}
 	slog.Info("cache miss")
 
 	start := time.Now()
-	slog.Info("starting streaming upload", "key", action.Key)
+	_, walk := StartSpan(ctx, SpanWalk)
+	manifest, err := BuildArchiveManifest(action.Artifacts, action.ArchiveConfig())
+	walk.SetError(err)
+	if err == nil {
+		walk.Set("files", manifest.FileCount, "bytes", manifest.TotalSize)
+	}
+	walk.End()
+	if err != nil {
+		return fmt.Errorf("failed to build manifest: %w", err)
+	}
+	if action.DryRun {
+		report.Outcome = OutcomeDryRun
+		report.UncompressedSize = manifest.TotalSize
+		report.FileCount = manifest.FileCount
+		slog.Info("dry run, nothing uploaded", "key", key,
+			"files", manifest.FileCount, "size", getReadableBytes(manifest.TotalSize))
+		return nil
+	}
+	slog.Info("starting streaming upload", "key", key,
+		"files", manifest.FileCount, "size", getReadableBytes(manifest.TotalSize))
 
-	reader, errChan := ZipStream(action.Artifacts, action.Compression, action.CompressionLevel)
-	ctx := context.Background()
+	uploadCtx, upload := StartSpan(ctx, SpanUpload, "key", key)
+	reader, errChan := ZipManifestStream(uploadCtx, manifest, action.ArchiveConfig())
 
-	uploadErr := StreamUpload(ctx, reader, action.Key, action.Bucket, action.S3Class, tc)
+	// Hash and count the archive as it streams out so it can be signed without re-reading it
+	digest := sha256.New()
+	counter := &countingWriter{}
+	uploadErr := StreamUpload(uploadCtx, io.TeeReader(reader, io.MultiWriter(digest, counter)), key, action.Bucket, action.S3Class, tc, action.archiveMetadata())
 	if uploadErr != nil {
 		reader.Close()
 	}
+	upload.SetError(uploadErr)
+	upload.Set("bytes", counter.n)
+	upload.End()
 
 	if compressErr := <-errChan; compressErr != nil {
 		return fmt.Errorf("failed to compress artifacts: %w", compressErr)
@@ -80,46 +163,280 @@ func runPut(action Action, tc TransferConfig) error {
 		return fmt.Errorf("failed to upload cache: %w", uploadErr)
 	}
 
-	slog.Info("cache saved successfully", "key", action.Key, "duration", time.Since(start))
+	if err := PutManifest(key, action.Bucket, manifest, action.Keyring); err != nil {
+		return fmt.Errorf("failed to upload cache manifest: %w", err)
+	}
+
+	if action.Signer != nil {
+		sig := action.Signer.Sign(key, formatDigest(digest.Sum(nil)))
+		if err := PutSignature(key, action.Bucket, sig); err != nil {
+			return fmt.Errorf("failed to upload cache signature: %w", err)
+		}
+		slog.Info("cache signed", "key_id", sig.KeyID, "digest", sig.Digest)
+	}
+
+	report.Outcome = OutcomeSaved
+	report.ArchiveSize = counter.n
+	report.UncompressedSize = manifest.TotalSize
+	report.FileCount = manifest.FileCount
+	report.Timings.Upload = time.Since(start)
+	slog.Info("cache saved successfully", "key", key, "duration", time.Since(start))
 	return nil
 }
 
-func runGet(action Action, tc TransferConfig) error {
-	slog.Info("attempting to restore cache", "key", action.Key)
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
 
-	exists, err := ObjectExists(action.Key, action.Bucket)
-	if err != nil {
-		return fmt.Errorf("failed to check if object exists: %w", err)
+// cacheMatch - A cache found by findCache
+type cacheMatch struct {
+	Key    string
+	Prefix string // restore key prefix the cache was found under, empty for an exact match
+}
+
+// findCache searches each scope namespace in order for the exact key, then for the
+// latest cache matching each restore key prefix. Returns nil if nothing matches.
+func findCache(action Action) (*cacheMatch, error) {
+	for _, ns := range action.Scope.readNamespaces() {
+		candidate := action.scopedKey(ns, action.Key)
+		exists, err := ObjectExists(candidate, action.Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if object exists: %w", err)
+		}
+		if exists {
+			return &cacheMatch{Key: candidate}, nil
+		}
+
+		for _, restoreKey := range action.RestoreKeys {
+			prefix := action.scopedKey(ns, restoreKey)
+			slog.Info("no cache found for key, trying default", "key", candidate, "default_key", prefix)
+			latest, err := GetLatestObject(prefix, action.Bucket)
+			if err == nil {
+				return &cacheMatch{Key: latest, Prefix: prefix}, nil
+			}
+			slog.Debug("no cache found for default key", "default_key", prefix, "error", err)
+		}
+	}
+	return nil, nil
+}
+
+// ErrCacheMiss is returned by get when no usable cache exists and fail-on-cache-miss is set.
+var ErrCacheMiss = errors.New("no usable cache found")
+
+// cacheMiss logs a miss, or turns it into an error when the action must fail on a miss.
+func cacheMiss(action Action, reason string, args ...any) error {
+	if action.FailOnCacheMiss {
+		return fmt.Errorf("%w: %s", ErrCacheMiss, reason)
 	}
+	slog.Warn(reason, args...)
+	return nil
+}
 
-	var filename string
-	if exists {
-		slog.Info("cache hit, starting download")
-		filename = action.Key
+func runGet(ctx context.Context, action Action, tc TransferConfig) error {
+	// Outputs are written on every return path so dependent steps never see stale values
+	report := CacheReport{}
+	result, err := restoreCache(ctx, action, tc, &report)
+	setGetOutputs(result)
+	WriteStepSummary(GetAction, []CacheReport{report})
+	recordMetrics(action, []CacheReport{report})
+	return err
+}
+
+// restoreCache finds and extracts the cache for action, reporting which key was restored.
+// Each phase is recorded as a span under the span in ctx.
+func restoreCache(ctx context.Context, action Action, tc TransferConfig, report *CacheReport) (result GetResult, err error) {
+	slog.Info("attempting to restore cache", "key", action.Key, "scope", action.Scope.Mode)
+	result.PrimaryKey = action.Key
+	report.Key = action.Key
+	report.Prefix = metricsPrefix(action)
+	report.Outcome = OutcomeMiss
+	start := time.Now()
+	defer func() { report.Timings.Total = time.Since(start) }()
+
+	listStart := time.Now()
+	_, lookup := StartSpan(ctx, SpanLookup, "key", action.Key)
+	match, err := findCache(action)
+	lookup.SetError(err)
+	if match != nil {
+		lookup.Set("matched_key", match.Key, "exact", match.Prefix == "")
+	}
+	lookup.End()
+	report.Timings.List = time.Since(listStart)
+	if err != nil {
+		return result, err
+	}
+	if match == nil {
+		return result, cacheMiss(action, "no cache found, skipping download")
+	}
+	key, exact := match.Key, match.Prefix == ""
+	if exact {
+		slog.Info("cache hit", "key", key)
 	} else {
-		slog.Info("no cache found for key, trying default", "key", action.Key, "default_key", action.DefaultKey)
-		filename, err = GetLatestObject(action.DefaultKey, action.Bucket)
+		slog.Info("defaulting to latest similar key", "filename", key)
+	}
+
+	// Check the key id before downloading so a cache encrypted with a rotated-out key is a miss
+	if props, err := ObjectProperties(key, action.Bucket); err == nil {
+		report.ArchiveSize = aws.ToInt64(props.ContentLength)
+		if keyID, ok := props.Metadata[metadataKeyID]; ok {
+			if _, found := action.Keyring.lookup(keyID); !found {
+				return result, cacheMiss(action, "cache is encrypted with an unavailable key, skipping download", "key", key, "key_id", keyID)
+			}
+		} else if action.Keyring != nil && !action.AllowUnencrypted {
+			return result, cacheMiss(action, "cache is not encrypted, skipping download", "key", key)
+		}
+		if problem := crossOSProblem(props.Metadata, action.CrossOSArchive, runtime.GOOS); problem != "" {
+			return result, cacheMiss(action, problem+", skipping download", "key", key)
+		}
+	}
+
+	if action.LookupOnly || action.DryRun {
+		// The archive digest needs the download, but a cache a get would reject for its
+		// signature must not be reported as found either
+		if action.TrustedKeys != nil {
+			if err := action.TrustedKeys.VerifySigner(key, fetchSignature(key, action)); err != nil {
+				return result, cacheMiss(action, fmt.Sprintf("untrusted cache: %v", err), "key", key)
+			}
+		}
+		result.MatchedKey = key
+		// A dry run restores nothing, so steps skipped on cache-hit must still run
+		result.Hit = exact && !action.DryRun
+		report.setMatch(match)
+		if action.DryRun {
+			slog.Info("dry run, skipping download", "key", key, "restore_key", match.Prefix, "exact", exact)
+		} else {
+			slog.Info("lookup only, skipping download", "key", key, "exact", exact)
+		}
+		return result, nil
+	}
+
+	// Keys may contain namespace separators, so download to a temp file rather than the key itself
+	archive, err := os.CreateTemp("", "s3cache-*"+keyExtension(action.Compression))
+	if err != nil {
+		return result, fmt.Errorf("failed to create download file: %w", err)
+	}
+	archive.Close()
+	archivePath := archive.Name()
+	defer os.Remove(archivePath)
+
+	slog.Info("starting download", "key", key)
+	downloadStart := time.Now()
+	downloadCtx, download := StartSpan(ctx, SpanDownload, "key", key, "bytes", report.ArchiveSize)
+	err = DownloadObject(downloadCtx, key, action.Bucket, archivePath, report.ArchiveSize, tc)
+	download.SetError(err)
+	download.End()
+	if err != nil {
+		return result, fmt.Errorf("failed to download cache: %w", err)
+	}
+	report.Timings.Download = time.Since(downloadStart)
+
+	if action.TrustedKeys != nil {
+		_, verify := StartSpan(ctx, SpanVerify, "key", key)
+		err := verifyDownload(key, archivePath, action)
+		verify.SetError(err)
+		verify.End()
 		if err != nil {
-			slog.Warn("no cache found, skipping download", "error", err)
-			return nil
+			return result, cacheMiss(action, fmt.Sprintf("untrusted cache, skipping restore: %v", err), "key", key)
 		}
-		slog.Info("defaulting to latest similar key", "filename", filename)
+		slog.Info("cache signature verified", "key", key)
 	}
 
-	if err := GetObject(filename, action.Bucket, tc); err != nil {
-		return fmt.Errorf("failed to download cache: %w", err)
+	extractCtx, extract := StartSpan(ctx, SpanExtract)
+	stats, err := UnzipArchive(archivePath, action.ArchiveConfig())
+	extract.SetError(err)
+	if errors.Is(err, ErrUnencryptedCache) {
+		// Metadata can be forged, so the archive itself decides; nothing was extracted yet
+		extract.End()
+		return result, cacheMiss(action, "cache is not encrypted, skipping restore", "key", key)
+	}
+	if err == nil {
+		// Decompression and writing interleave, so each is recorded as its accumulated time
+		recordPhase(extractCtx, SpanDecompress, extract.StartTime, stats.Decompress)
+		recordPhase(extractCtx, SpanWriteFiles, extract.StartTime, stats.Extract, "files", stats.Files, "bytes", stats.Bytes)
 	}
+	extract.End()
+	if err != nil {
+		return result, fmt.Errorf("failed to unzip cache: %w", err)
+	}
+	result.MatchedKey, result.Hit = key, exact
+	report.setMatch(match)
+	report.UncompressedSize = stats.Bytes
+	report.FileCount = stats.Files
+	report.Timings.Decompress = stats.Decompress
+	report.Timings.Extract = stats.Extract
 
-	if err := Unzip(filename, action.Compression); err != nil {
-		return fmt.Errorf("failed to unzip cache: %w", err)
+	if action.TrackAccess {
+		// Access tracking only feeds pruning, so a read-only role must not fail the restore
+		if err := RecordAccess(key, action.Bucket); err != nil {
+			slog.Warn("failed to record cache access", "key", key, "error", err)
+		}
 	}
 
-	return nil
+	return result, nil
+}
+
+// verifyDownload checks the downloaded archive's signature against the trusted keys.
+func verifyDownload(key string, archivePath string, action Action) error {
+	digest, err := fileDigest(archivePath)
+	if err != nil {
+		return err
+	}
+	return action.TrustedKeys.Verify(key, digest, fetchSignature(key, action))
+}
+
+// fetchSignature returns the cache's signature sidecar, or nil if it has none.
+func fetchSignature(key string, action Action) *Signature {
+	sig, err := GetSignature(key, action.Bucket)
+	if err != nil {
+		// A missing sidecar means the cache was never signed
+		slog.Debug("failed to fetch cache signature", "key", key, "error", err)
+		return nil
+	}
+	return sig
 }
 
 func runDelete(action Action) error {
-	if err := DeleteObject(action.Key, action.Bucket); err != nil {
+	if action.DryRun {
+		return planDelete(action.storeKey(), action.Bucket)
+	}
+	if err := DeleteObject(action.storeKey(), action.Bucket); err != nil {
 		return fmt.Errorf("failed to delete cache: %w", err)
 	}
 	return nil
 }
+
+// planDelete prints the cache archive and sidecars a delete of key would remove, without deleting them.
+// Like DeleteObject, sidecars are only removed along with an existing archive.
+func planDelete(key string, bucket string) error {
+	var objects []CacheObject
+	for _, k := range append([]string{key}, sidecarKeys(key)...) {
+		props, err := ObjectProperties(k, bucket)
+		if err != nil {
+			if k == key {
+				slog.Warn("dry run, cache does not exist", "key", key)
+				return nil
+			}
+			continue
+		}
+		objects = append(objects, CacheObject{Key: k, Size: aws.ToInt64(props.ContentLength)})
+	}
+
+	var size int64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSIZE")
+	for _, o := range objects {
+		size += o.Size
+		fmt.Fprintf(tw, "%s\t%s\n", o.Key, getReadableBytes(o.Size))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	slog.Info("dry run, nothing deleted", "key", key, "would_delete", len(objects), "would_free", getReadableBytes(size))
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// fakeS3 - An in-memory bucket serving the path-style HeadObject, GetObject and
+	// ListObjectsV2 requests a get makes, so restores can be tested without MinIO.
+	fakeS3 struct {
+		mu      sync.Mutex
+		bucket  string
+		objects map[string]fakeObject
+		gets    map[string]int // GetObject requests per key
+	}
+
+	fakeObject struct {
+		data     []byte
+		metadata map[string]string
+		modTime  time.Time
+	}
+
+	fakeListResult struct {
+		XMLName     xml.Name          `xml:"ListBucketResult"`
+		Name        string            `xml:"Name"`
+		Prefix      string            `xml:"Prefix"`
+		KeyCount    int               `xml:"KeyCount"`
+		MaxKeys     int               `xml:"MaxKeys"`
+		IsTruncated bool              `xml:"IsTruncated"`
+		Contents    []fakeListContent `xml:"Contents"`
+	}
+
+	fakeListContent struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int    `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+)
+
+// newFakeS3 starts a fake bucket and points the shared S3 client at it for the test.
+func newFakeS3(t *testing.T, bucket string) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{bucket: bucket, objects: make(map[string]fakeObject), gets: make(map[string]int)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_S3_ENDPOINT", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	resetS3Client()
+	t.Cleanup(resetS3Client)
+	return f
+}
+
+func (f *fakeS3) put(key string, data []byte, metadata map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = fakeObject{data: data, metadata: metadata, modTime: time.Now().UTC().Truncate(time.Second)}
+}
+
+func (f *fakeS3) getCount(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gets[key]
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			f.gets[key]++
+		}
+		for k, v := range obj.metadata {
+			w.Header().Set("x-amz-meta-"+k, v)
+		}
+		w.Header().Set("ETag", `"fake"`)
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	result := fakeListResult{Name: f.bucket, Prefix: prefix, MaxKeys: 1000}
+	for key, obj := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, fakeListContent{
+				Key:          key,
+				LastModified: obj.modTime.Format(time.RFC3339),
+				ETag:         `"fake"`,
+				Size:         len(obj.data),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// putTestCache archives dir into the fake bucket under key, signed by signer if not nil.
+func putTestCache(t *testing.T, f *fakeS3, key string, dir string, signer *Signer) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.tar.zst")
+	if err := Zip(path, []string{dir}, CompressionZstd, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	f.put(key, data, nil)
+	if signer != nil {
+		digest, _ := fileDigest(path)
+		sig, _ := json.Marshal(signer.Sign(key, digest))
+		f.put(signatureKey(key), sig, nil)
+	}
+}
+
+func TestRestoreCacheLookupOnlyAndFailOnMiss(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	f := newFakeS3(t, "cache-bucket")
+	signer, pub := newTestSigner(t)
+	trusted, _ := ParseTrustedKeys(pub, "")
+	untrustedSigner, _ := newTestSigner(t)
+
+	os.MkdirAll("deps", 0755)
+	os.WriteFile("deps/lib.txt", []byte("lib"), 0644)
+	putTestCache(t, f, "linux-yarn-abc.tar.zst", "deps", signer)
+	putTestCache(t, f, "linux-gradle-old.tar.zst", "deps", untrustedSigner)
+	putTestCache(t, f, "linux-unsigned-abc.tar.zst", "deps", nil)
+	os.RemoveAll("deps")
+
+	get := func(key string, defaultKey string) Action {
+		return Action{
+			Action:      GetAction,
+			Bucket:      "cache-bucket",
+			Key:         key,
+			DefaultKey:  defaultKey,
+			RestoreKeys: []string{defaultKey},
+			Compression: CompressionZstd,
+		}
+	}
+
+	t.Run("lookup_only_hit", func(t *testing.T) {
+		action := get("linux-yarn-abc.tar.zst", "linux-yarn-")
+		action.LookupOnly = true
+		result, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{})
+		if err != nil {
+			t.Fatalf("restoreCache failed: %v", err)
+		}
+		if !result.Hit || result.MatchedKey != "linux-yarn-abc.tar.zst" {
+			t.Errorf("expected an exact hit, got %+v", result)
+		}
+		if n := f.getCount("linux-yarn-abc.tar.zst"); n != 0 {
+			t.Errorf("lookup-only downloaded the cache %d times", n)
+		}
+		if _, err := os.Stat("deps"); !os.IsNotExist(err) {
+			t.Error("lookup-only must not restore files")
+		}
+	})
+
+	t.Run("lookup_only_partial_hit", func(t *testing.T) {
+		action := get("linux-yarn-new.tar.zst", "linux-yarn-")
+		action.LookupOnly = true
+		result, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{})
+		if err != nil {
+			t.Fatalf("restoreCache failed: %v", err)
+		}
+		if result.Hit || result.MatchedKey != "linux-yarn-abc.tar.zst" {
+			t.Errorf("expected a restore key match without a hit, got %+v", result)
+		}
+	})
+
+	t.Run("lookup_only_miss", func(t *testing.T) {
+		action := get("macos-yarn-abc.tar.zst", "macos-yarn-")
+		action.LookupOnly = true
+		result, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{})
+		if err != nil {
+			t.Fatalf("a miss without fail-on-cache-miss must not fail: %v", err)
+		}
+		if result.Hit || result.MatchedKey != "" {
+			t.Errorf("expected a miss, got %+v", result)
+		}
+	})
+
+	t.Run("fail_on_cache_miss_missing_key", func(t *testing.T) {
+		for _, lookupOnly := range []bool{false, true} {
+			action := get("macos-yarn-abc.tar.zst", "macos-yarn-")
+			action.LookupOnly = lookupOnly
+			action.FailOnCacheMiss = true
+			if _, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{}); !errors.Is(err, ErrCacheMiss) {
+				t.Errorf("lookup-only %v: expected ErrCacheMiss, got %v", lookupOnly, err)
+			}
+		}
+	})
+
+	t.Run("fail_on_cache_miss_untrusted", func(t *testing.T) {
+		for _, key := range []string{"linux-gradle-old.tar.zst", "linux-unsigned-abc.tar.zst"} {
+			for _, lookupOnly := range []bool{false, true} {
+				action := get(key, "")
+				action.RestoreKeys = nil
+				action.TrustedKeys = trusted
+				action.LookupOnly = lookupOnly
+				action.FailOnCacheMiss = true
+				result, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{})
+				if !errors.Is(err, ErrCacheMiss) || result.Hit {
+					t.Errorf("%s, lookup-only %v: expected ErrCacheMiss without a hit, got %+v, %v", key, lookupOnly, result, err)
+				}
+				if _, err := os.Stat("deps"); !os.IsNotExist(err) {
+					t.Fatalf("%s: an untrusted cache must not be restored", key)
+				}
+			}
+		}
+	})
+
+	t.Run("trusted_restore", func(t *testing.T) {
+		action := get("linux-yarn-abc.tar.zst", "linux-yarn-")
+		action.TrustedKeys = trusted
+		action.FailOnCacheMiss = true
+		result, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{})
+		if err != nil || !result.Hit {
+			t.Fatalf("expected a verified hit, got %+v, %v", result, err)
+		}
+		if data, err := os.ReadFile("deps/lib.txt"); err != nil || string(data) != "lib" {
+			t.Errorf("expected deps/lib.txt restored, got %q, %v", data, err)
+		}
+	})
+}
+
+func TestRunGetOutputsOnMiss(t *testing.T) {
+	newFakeS3(t, "cache-bucket")
+	outputs := filepath.Join(t.TempDir(), "outputs")
+	t.Setenv("GITHUB_OUTPUT", outputs)
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+
+	action := Action{
+		Action:          GetAction,
+		Bucket:          "cache-bucket",
+		Key:             "linux-yarn-abc.tar.zst",
+		DefaultKey:      "linux-yarn-",
+		RestoreKeys:     []string{"linux-yarn-"},
+		Compression:     CompressionZstd,
+		LookupOnly:      true,
+		FailOnCacheMiss: true,
+	}
+	if err := runGet(context.Background(), action, TransferConfig{}); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+
+	// Outputs are still written so later steps see the miss
+	data, err := os.ReadFile(outputs)
+	if err != nil {
+		t.Fatalf("failed to read outputs: %v", err)
+	}
+	for _, want := range []string{"cache-hit=false\n", "cache-primary-key=linux-yarn-abc.tar.zst\n", "cache-matched-key=\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("outputs %q missing %q", data, want)
+		}
+	}
+}
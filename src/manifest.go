@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// manifestEntryName is the name of the tar entry holding the manifest.
+	// It is always written as the first entry of the archive.
+	manifestEntryName = ".s3cache-manifest.json"
+
+	// manifestSuffix is appended to a cache key to form the sidecar manifest object key.
+	manifestSuffix = ".manifest.json"
+
+	// manifestFormatVersion is bumped whenever the manifest layout changes incompatibly.
+	manifestFormatVersion = 1
+
+	// Manifest entry types
+	EntryTypeFile    = "file"
+	EntryTypeDir     = "dir"
+	EntryTypeSymlink = "symlink"
+)
+
+// Version is the tool version recorded in manifests. Overridden at build time via -ldflags.
+var Version = "dev"
+
+type (
+	// Manifest describes the contents of a cache archive.
+	Manifest struct {
+		FormatVersion int             `json:"format_version"`
+		ToolVersion   string          `json:"tool_version"`
+		Compression   string          `json:"compression"`
+		OS            string          `json:"os,omitempty"`       // GOOS of the runner that created the archive
+		CrossOS       bool            `json:"cross_os,omitempty"` // names and modes are portable between OSes
+		Reproducible  bool            `json:"reproducible,omitempty"`
+		Patterns      []string        `json:"patterns"`
+		CreatedAt     time.Time       `json:"created_at"`
+		FileCount     int             `json:"file_count"`
+		TotalSize     int64           `json:"total_size"`
+		Files         []ManifestEntry `json:"files"`
+	}
+
+	// ManifestEntry describes a single archived path.
+	ManifestEntry struct {
+		Path     string    `json:"path"`
+		Type     string    `json:"type"`
+		Size     int64     `json:"size,omitempty"`
+		Mode     int64     `json:"mode"`
+		ModTime  time.Time `json:"mtime"`
+		Linkname string    `json:"linkname,omitempty"`
+		SHA256   string    `json:"sha256,omitempty"`
+
+		// source is the on-disk path the entry was read from.
+		source string
+		info   os.FileInfo
+	}
+)
+
+// manifestKey returns the sidecar object key holding the manifest for the given cache key.
+func manifestKey(key string) string {
+	return key + manifestSuffix
+}
+
+// isManifestKey reports whether the object key is a sidecar manifest rather than a cache archive.
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix)
+}
+
+// BuildManifest resolves the artifact glob patterns and hashes every matching file.
+// Patterns may start with ~ and reference environment variables; the manifest keeps them unexpanded.
+// The returned manifest is used to drive archive creation.
+func BuildManifest(artifacts []string, compression string) (*Manifest, error) {
+	m := &Manifest{
+		FormatVersion: manifestFormatVersion,
+		ToolVersion:   Version,
+		Compression:   compression,
+		OS:            runtime.GOOS,
+		Patterns:      artifacts,
+		CreatedAt:     time.Now().UTC(),
+		Files:         []ManifestEntry{},
+	}
+
+	encoder := newPathEncoder()
+	// Overlapping patterns, such as "a" and "a/b", walk the same paths more than once
+	seen := make(map[string]bool)
+	for _, pattern := range artifacts {
+		pattern, err := expandPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("processing pattern", "pattern", pattern, "matches", len(matches))
+		if len(matches) == 0 {
+			slog.Warn("no matches for pattern", "pattern", pattern)
+		}
+		for _, match := range matches {
+			walkErr := filepath.Walk(match, func(file string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				source := file
+				if abs, err := filepath.Abs(file); err == nil {
+					source = abs
+				}
+				if seen[source] {
+					// A directory walked before was walked with everything below it
+					if fi.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				seen[source] = true
+				entry, err := newManifestEntry(file, encoder.encode(file), fi)
+				if err != nil {
+					return err
+				}
+				if entry.Type == EntryTypeFile {
+					m.FileCount++
+					m.TotalSize += entry.Size
+				}
+				m.Files = append(m.Files, entry)
+				return nil
+			})
+			if walkErr != nil {
+				return nil, walkErr
+			}
+		}
+	}
+	return m, nil
+}
+
+// BuildArchiveManifest builds the manifest for an archive created with ac, with the
+// path rewrite rules applied to the names it stores. Cross-OS archives are checked for
+// names that cannot be restored everywhere, and reproducible ones sorted and clamped.
+func BuildArchiveManifest(artifacts []string, ac ArchiveConfig) (*Manifest, error) {
+	m, err := BuildManifest(artifacts, ac.Compression)
+	if err != nil {
+		return nil, err
+	}
+	m.rewritePaths(ac.Rewrites)
+	if ac.CrossOS {
+		if err := m.makePortable(); err != nil {
+			return nil, err
+		}
+	}
+	if ac.Reproducible {
+		epoch := ac.SourceDateEpoch
+		if epoch.IsZero() {
+			epoch = time.Unix(0, 0)
+		}
+		m.makeReproducible(epoch)
+	}
+	return m, nil
+}
+
+// newManifestEntry builds a manifest entry for a walked path stored under name, hashing regular files.
+func newManifestEntry(file string, name string, fi os.FileInfo) (ManifestEntry, error) {
+	entry := ManifestEntry{
+		Path:    name,
+		Mode:    int64(fi.Mode().Perm()),
+		ModTime: fi.ModTime().UTC(),
+		source:  file,
+		info:    fi,
+	}
+
+	switch {
+	case fi.IsDir():
+		entry.Type = EntryTypeDir
+	case fi.Mode()&os.ModeSymlink != 0:
+		entry.Type = EntryTypeSymlink
+		link, err := os.Readlink(file)
+		if err != nil {
+			return entry, err
+		}
+		entry.Linkname = link
+	default:
+		entry.Type = EntryTypeFile
+		entry.Size = fi.Size()
+		sum, err := hashFile(file)
+		if err != nil {
+			return entry, err
+		}
+		entry.SHA256 = sum
+	}
+	return entry, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 of a file's contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeManifestEntry writes the manifest as a tar entry.
+func writeManifestEntry(tw *tar.Writer, m *Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestEntryName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  m.CreatedAt,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// readManifest decodes a manifest from r.
+func readManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// fileHashes returns a lookup of archived file path to expected SHA-256.
+func (m *Manifest) fileHashes() map[string]string {
+	hashes := make(map[string]string, m.FileCount)
+	for _, f := range m.Files {
+		if f.Type == EntryTypeFile {
+			hashes[f.Path] = f.SHA256
+		}
+	}
+	return hashes
+}
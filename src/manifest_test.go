@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildManifest(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "manifest_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	if err := os.MkdirAll("mdata/sub", 0755); err != nil {
+		t.Fatalf("failed to create test dir: %v", err)
+	}
+	os.WriteFile("mdata/a.txt", []byte("hello"), 0644)
+	os.WriteFile("mdata/sub/b.txt", []byte("world!"), 0600)
+
+	m, err := BuildManifest([]string{"mdata"}, CompressionZstd)
+	if err != nil {
+		t.Fatalf("BuildManifest failed: %v", err)
+	}
+
+	if m.FileCount != 2 {
+		t.Errorf("FileCount = %d, want 2", m.FileCount)
+	}
+	if m.TotalSize != 11 {
+		t.Errorf("TotalSize = %d, want 11", m.TotalSize)
+	}
+	if m.Compression != CompressionZstd {
+		t.Errorf("Compression = %q, want %q", m.Compression, CompressionZstd)
+	}
+	if len(m.Patterns) != 1 || m.Patterns[0] != "mdata" {
+		t.Errorf("Patterns = %v, want [mdata]", m.Patterns)
+	}
+
+	hashes := m.fileHashes()
+	// sha256("hello")
+	if got := hashes["mdata/a.txt"]; got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("unexpected hash for mdata/a.txt: %s", got)
+	}
+	for _, f := range m.Files {
+		if f.Path == "mdata/sub/b.txt" && f.Mode != 0600 {
+			t.Errorf("mode for %s = %o, want 600", f.Path, f.Mode)
+		}
+	}
+}
+
+func TestBuildManifestOverlappingPatterns(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("a/b", 0755)
+	os.WriteFile("a/top.txt", []byte("top"), 0644)
+	os.WriteFile("a/b/f.txt", []byte("nested"), 0644)
+
+	// Listed in both orders, and once more through an absolute path
+	patterns := []string{"a/b", "a", filepath.Join(tempDir, "a", "b", "f.txt")}
+	m, err := BuildManifest(patterns, CompressionZstd)
+	if err != nil {
+		t.Fatalf("BuildManifest failed: %v", err)
+	}
+	if m.FileCount != 2 || len(m.Files) != 4 {
+		t.Errorf("expected 2 files in 4 entries, got %d files in %d entries", m.FileCount, len(m.Files))
+	}
+
+	if err := Zip("overlap.tar.zst", patterns, CompressionZstd, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	os.RemoveAll("a")
+	if err := Unzip("overlap.tar.zst", CompressionZstd); err != nil {
+		t.Fatalf("Unzip failed: %v", err)
+	}
+	if data, err := os.ReadFile("a/b/f.txt"); err != nil || string(data) != "nested" {
+		t.Errorf("expected a/b/f.txt restored, got %q, %v", data, err)
+	}
+}
+
+func TestZipWritesManifestFirst(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "manifest_first_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	os.MkdirAll("mfirst", 0755)
+	os.WriteFile("mfirst/file.txt", []byte("content"), 0644)
+
+	if err := Zip("out.tar", []string{"mfirst"}, CompressionNone, 0); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+
+	f, err := os.Open("out.tar")
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatalf("failed to read first entry: %v", err)
+	}
+	if header.Name != manifestEntryName {
+		t.Fatalf("first entry = %q, want %q", header.Name, manifestEntryName)
+	}
+	m, err := readManifest(tr)
+	if err != nil {
+		t.Fatalf("readManifest failed: %v", err)
+	}
+	if m.FileCount != 1 || m.ToolVersion != Version {
+		t.Errorf("unexpected manifest: %+v", m)
+	}
+	// sha256("content")
+	if got := m.fileHashes()["mfirst/file.txt"]; got != "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73" {
+		t.Errorf("unexpected hash for mfirst/file.txt: %s", got)
+	}
+}
+
+func TestArchiveDetectsChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "changes.txt")
+
+	for _, changed := range []string{"SHORT", "much longer"} {
+		os.WriteFile(file, []byte("short"), 0644)
+		m, err := BuildManifest([]string{file}, CompressionNone)
+		if err != nil {
+			t.Fatalf("BuildManifest failed: %v", err)
+		}
+		os.WriteFile(file, []byte(changed), 0644)
+		err = archiveArtifacts(tar.NewWriter(io.Discard), m, ArchiveConfig{})
+		if err == nil || !strings.Contains(err.Error(), "changed while archiving") {
+			t.Errorf("%q: expected a changed file error, got %v", changed, err)
+		}
+	}
+}
+
+func TestUnzipDetectsChecksumMismatch(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "manifest_mismatch_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	// Hand-craft an archive whose manifest disagrees with the file contents
+	out, err := os.Create("bad.tar")
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	tw := tar.NewWriter(out)
+	m := &Manifest{
+		FormatVersion: manifestFormatVersion,
+		Compression:   CompressionNone,
+		CreatedAt:     time.Now(),
+		FileCount:     1,
+		Files: []ManifestEntry{
+			{Path: "bad/file.txt", Type: EntryTypeFile, Size: 8, SHA256: "0000"},
+		},
+	}
+	if err := writeManifestEntry(tw, m); err != nil {
+		t.Fatalf("writeManifestEntry failed: %v", err)
+	}
+	content := []byte("tampered")
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bad/file.txt", Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	out.Close()
+
+	if err := Unzip("bad.tar", CompressionNone); err == nil {
+		t.Fatal("expected checksum mismatch error, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, manifestEntryName)); !os.IsNotExist(err) {
+		t.Error("manifest entry should not be extracted to disk")
+	}
+}
+
+func TestIsManifestKey(t *testing.T) {
+	if !isManifestKey(manifestKey("linux-yarn-abc.tar.zst")) {
+		t.Error("expected sidecar key to be detected")
+	}
+	if isManifestKey("linux-yarn-abc.tar.zst") {
+		t.Error("archive key should not be detected as manifest")
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are written in the Prometheus text exposition format, either to a file for the
+// node_exporter textfile collector or pushed to a Pushgateway when the action finishes.
+
+const (
+	metricOperations    = "s3cache_operations_total"
+	metricDownloadBytes = "s3cache_downloaded_bytes_total"
+	metricUploadBytes   = "s3cache_uploaded_bytes_total"
+	metricArchiveBytes  = "s3cache_archive_bytes_total"
+	metricRawBytes      = "s3cache_uncompressed_bytes_total"
+	metricDuration      = "s3cache_operation_duration_seconds"
+	metricLastRun       = "s3cache_last_run_timestamp_seconds"
+
+	defaultMetricsJob  = "s3_cache"
+	pushgatewayTimeout = 10 * time.Second
+)
+
+// durationBuckets are the histogram upper bounds, in seconds, for operation durations.
+var durationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// metricFamily - The name, type and help text of one metric
+type metricFamily struct {
+	name string
+	typ  string
+	help string
+}
+
+var metricFamilies = []metricFamily{
+	{metricOperations, "counter", "Cache operations by outcome: exact, partial or miss for get; saved or exists for put."},
+	{metricDownloadBytes, "counter", "Archive bytes downloaded from S3."},
+	{metricUploadBytes, "counter", "Archive bytes uploaded to S3."},
+	{metricArchiveBytes, "counter", "Compressed size of the archives saved or restored."},
+	{metricRawBytes, "counter", "Uncompressed size of the files saved or restored."},
+	{metricDuration, "histogram", "Wall time of each get or put, from lookup to the last file written or part uploaded."},
+	{metricLastRun, "gauge", "Unix time the action last finished."},
+}
+
+type (
+	// MetricsConfig - Where cache metrics are written once the action finishes
+	MetricsConfig struct {
+		TextfilePath   string // Prometheus textfile, empty = none
+		PushgatewayURL string // Pushgateway base URL, empty = no push
+		Job            string // Pushgateway job label
+		Instance       string // Pushgateway instance grouping label, the key prefix by default
+	}
+
+	// metricSet - Sample values keyed by family, then by the rendered series, e.g. `name{a="b"}`
+	metricSet struct {
+		mu      sync.Mutex
+		samples map[string]map[string]float64
+		order   map[string][]string // series in first-seen order, so histogram buckets stay sorted
+	}
+)
+
+// metrics collects the samples of this run.
+var metrics = newMetricSet()
+
+func newMetricSet() *metricSet {
+	return &metricSet{samples: make(map[string]map[string]float64), order: make(map[string][]string)}
+}
+
+// ParseMetrics reads the metrics export settings.
+func ParseMetrics(p *configProblems) MetricsConfig {
+	cfg := MetricsConfig{
+		TextfilePath:   strings.TrimSpace(os.Getenv("METRICS_TEXTFILE")),
+		PushgatewayURL: strings.TrimSpace(os.Getenv("METRICS_PUSHGATEWAY_URL")),
+		Job:            strings.TrimSpace(os.Getenv("METRICS_JOB")),
+	}
+	if cfg.Job == "" {
+		cfg.Job = defaultMetricsJob
+	}
+	cfg.Instance = strings.TrimSpace(os.Getenv("METRICS_INSTANCE"))
+	if cfg.Instance == "" {
+		cfg.Instance = defaultMetricsInstance()
+	}
+	if cfg.PushgatewayURL != "" {
+		if u, err := url.Parse(cfg.PushgatewayURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			p.errorf("invalid METRICS_PUSHGATEWAY_URL %q: must be an http or https URL", cfg.PushgatewayURL)
+			cfg.PushgatewayURL = ""
+		}
+	}
+	return cfg
+}
+
+func (c MetricsConfig) enabled() bool {
+	return c.TextfilePath != "" || c.PushgatewayURL != ""
+}
+
+// recordMetrics adds the outcome, sizes and duration of every report to the run's metrics.
+// Dry runs are not recorded, so they cannot skew hit rates.
+func recordMetrics(action Action, reports []CacheReport) {
+	if action.DryRun {
+		return
+	}
+	for _, r := range reports {
+		metrics.observe(action.Action, r)
+	}
+	metrics.set(metricLastRun, []string{"action", action.Action}, float64(time.Now().Unix()))
+}
+
+func (m *metricSet) observe(action string, r CacheReport) {
+	labels := []string{"action", action, "key_prefix", r.Prefix}
+	if r.Name != "" {
+		labels = append(labels, "cache", r.Name)
+	}
+	outcome := r.Outcome
+	if outcome == "" {
+		// A put that failed before finding out whether the key exists
+		outcome = "error"
+	}
+	withOutcome := append(slices.Clone(labels), "outcome", outcome)
+
+	m.add(metricOperations, withOutcome, 1)
+	m.observeDuration(withOutcome, r.Timings.Total)
+
+	switch action {
+	case GetAction:
+		if r.Timings.Download > 0 {
+			m.add(metricDownloadBytes, labels, float64(r.ArchiveSize))
+		}
+		if r.UncompressedSize > 0 {
+			m.add(metricArchiveBytes, labels, float64(r.ArchiveSize))
+			m.add(metricRawBytes, labels, float64(r.UncompressedSize))
+		}
+	case PutAction:
+		if r.Outcome == OutcomeSaved {
+			m.add(metricUploadBytes, labels, float64(r.ArchiveSize))
+			m.add(metricArchiveBytes, labels, float64(r.ArchiveSize))
+			m.add(metricRawBytes, labels, float64(r.UncompressedSize))
+		}
+	}
+}
+
+// observeDuration records d in the duration histogram's cumulative buckets.
+func (m *metricSet) observeDuration(labels []string, d time.Duration) {
+	seconds := d.Seconds()
+	for _, le := range durationBuckets {
+		inBucket := 0.0
+		if seconds <= le {
+			inBucket = 1
+		}
+		m.add(metricDuration+"_bucket", append(slices.Clone(labels), "le", formatFloat(le)), inBucket)
+	}
+	m.add(metricDuration+"_bucket", append(slices.Clone(labels), "le", "+Inf"), 1)
+	m.add(metricDuration+"_sum", labels, seconds)
+	m.add(metricDuration+"_count", labels, 1)
+}
+
+// add increases a sample. name may carry a histogram suffix; it is stored under its family.
+func (m *metricSet) add(name string, labels []string, v float64) {
+	m.update(name, labels, func(old float64) float64 { return old + v })
+}
+
+// set replaces a sample.
+func (m *metricSet) set(name string, labels []string, v float64) {
+	m.update(name, labels, func(float64) float64 { return v })
+}
+
+func (m *metricSet) update(name string, labels []string, fn func(float64) float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateSeries(familyOf(name), seriesKey(name, labels), fn)
+}
+
+func (m *metricSet) updateSeries(family string, series string, fn func(float64) float64) {
+	samples, ok := m.samples[family]
+	if !ok {
+		samples = make(map[string]float64)
+		m.samples[family] = samples
+	}
+	if _, ok := samples[series]; !ok {
+		m.order[family] = append(m.order[family], series)
+	}
+	samples[series] = fn(samples[series])
+}
+
+func (m *metricSet) empty() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.samples) == 0
+}
+
+// merged returns previous plus this run's samples: counters and histograms are summed, gauges
+// replaced. Series of this run not in previous are appended, and previous series kept as is.
+func (m *metricSet) merged(previous *metricSet) *metricSet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := newMetricSet()
+	for _, f := range metricFamilies {
+		for _, series := range previous.order[f.name] {
+			out.updateSeries(f.name, series, func(float64) float64 { return previous.samples[f.name][series] })
+		}
+		for _, series := range m.order[f.name] {
+			v := m.samples[f.name][series]
+			if f.typ == "gauge" {
+				out.updateSeries(f.name, series, func(float64) float64 { return v })
+			} else {
+				out.updateSeries(f.name, series, func(old float64) float64 { return old + v })
+			}
+		}
+	}
+	return out
+}
+
+// WriteTo renders the samples in the Prometheus text format.
+func (m *metricSet) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b bytes.Buffer
+	for _, f := range metricFamilies {
+		if len(m.order[f.name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, series := range m.order[f.name] {
+			fmt.Fprintf(&b, "%s %s\n", series, formatFloat(m.samples[f.name][series]))
+		}
+	}
+	return b.WriteTo(w)
+}
+
+// readMetrics parses samples previously written by WriteTo. Unknown metrics are dropped.
+func readMetrics(r io.Reader) (*metricSet, error) {
+	m := newMetricSet()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("malformed sample %q", line)
+		}
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed sample %q: %w", line, err)
+		}
+		series := line[:i]
+		name, _, _ := strings.Cut(series, "{")
+		family := familyOf(name)
+		if !slices.ContainsFunc(metricFamilies, func(f metricFamily) bool { return f.name == family }) {
+			continue
+		}
+		m.updateSeries(family, series, func(float64) float64 { return v })
+	}
+	return m, scanner.Err()
+}
+
+// WriteTextfile adds this run's samples to those already in path and atomically replaces it,
+// so the textfile collector never reads a partial file and counters keep growing across runs.
+// Concurrent jobs writing the same file can lose each other's increments; give each its own.
+func (m *metricSet) WriteTextfile(path string) error {
+	previous := newMetricSet()
+	if f, err := os.Open(path); err == nil {
+		previous, err = readMetrics(f)
+		f.Close()
+		if err != nil {
+			slog.Warn("discarding unreadable metrics file", "path", path, "error", err)
+			previous = newMetricSet()
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".s3cache-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := m.merged(previous).WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// defaultMetricsInstance returns the key prefix, usually the repository, without its trailing
+// slash. The grouping key must stay the same across runs: the Pushgateway never expires groups,
+// so a per-run value would add a new set of series on every run. Empty if keys are not prefixed.
+func defaultMetricsInstance() string {
+	return strings.TrimSuffix(keyPrefixFromEnv(), "/")
+}
+
+// groupingKey renders a Pushgateway grouping label as URL path segments. Values containing a
+// slash, or empty ones, use the gateway's base64 form.
+func groupingKey(label string, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		return "/" + label + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return "/" + label + "/" + url.PathEscape(value)
+}
+
+// Push sends this run's samples to the Pushgateway under the job and instance grouping key,
+// replacing only an earlier push of the same group.
+func (m *metricSet) Push(ctx context.Context, gatewayURL string, job string, instance string) error {
+	var body bytes.Buffer
+	if _, err := m.WriteTo(&body); err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(gatewayURL, "/") + "/metrics" + groupingKey("job", job)
+	if instance != "" {
+		target += groupingKey("instance", instance)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// ExportMetrics writes and pushes the run's metrics as configured. Like telemetry, metrics are
+// best effort: failures are logged and never fail the action.
+func ExportMetrics(cfg MetricsConfig) {
+	if !cfg.enabled() || metrics.empty() {
+		return
+	}
+	if cfg.TextfilePath != "" {
+		if err := metrics.WriteTextfile(cfg.TextfilePath); err != nil {
+			slog.Warn("failed to write metrics textfile", "path", cfg.TextfilePath, "error", err)
+		} else {
+			slog.Debug("wrote metrics textfile", "path", cfg.TextfilePath)
+		}
+	}
+	if cfg.PushgatewayURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), pushgatewayTimeout)
+		defer cancel()
+		if err := metrics.Push(ctx, cfg.PushgatewayURL, cfg.Job, cfg.Instance); err != nil {
+			slog.Warn("failed to push metrics", "url", cfg.PushgatewayURL, "error", err)
+		} else {
+			slog.Debug("pushed metrics", "url", cfg.PushgatewayURL, "job", cfg.Job, "instance", cfg.Instance)
+		}
+	}
+}
+
+// metricsPrefix returns the key prefix a cache is grouped under in metrics: its first restore
+// key if it has one, otherwise the repository key prefix.
+func metricsPrefix(a Action) string {
+	for _, rk := range a.RestoreKeys {
+		if rk != "" && rk != a.KeyPrefix {
+			return rk
+		}
+	}
+	return a.KeyPrefix
+}
+
+// familyOf strips the histogram sample suffixes from name.
+func familyOf(name string) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if family, ok := strings.CutSuffix(name, suffix); ok && family == metricDuration {
+			return family
+		}
+	}
+	return name
+}
+
+// seriesKey renders a sample name with its label pairs, e.g. `name{a="b",c="d"}`.
+func seriesKey(name string, labels []string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
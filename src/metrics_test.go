@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getReport() CacheReport {
+	return CacheReport{
+		Key:              "repo/linux-yarn-abc.tar.zst",
+		Prefix:           "repo/linux-yarn",
+		Outcome:          OutcomeExact,
+		ArchiveSize:      1000,
+		UncompressedSize: 4000,
+		Timings:          PhaseTimings{Download: time.Second, Total: 3 * time.Second},
+	}
+}
+
+func TestMetricSetRender(t *testing.T) {
+	m := newMetricSet()
+	m.observe(GetAction, getReport())
+	m.observe(GetAction, CacheReport{Prefix: "repo/linux-yarn", Outcome: OutcomeMiss, Timings: PhaseTimings{Total: 200 * time.Millisecond}})
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE s3cache_operations_total counter\n",
+		`s3cache_operations_total{action="get",key_prefix="repo/linux-yarn",outcome="exact"} 1` + "\n",
+		`s3cache_operations_total{action="get",key_prefix="repo/linux-yarn",outcome="miss"} 1` + "\n",
+		`s3cache_downloaded_bytes_total{action="get",key_prefix="repo/linux-yarn"} 1000` + "\n",
+		`s3cache_uncompressed_bytes_total{action="get",key_prefix="repo/linux-yarn"} 4000` + "\n",
+		"# TYPE s3cache_operation_duration_seconds histogram\n",
+		`s3cache_operation_duration_seconds_bucket{action="get",key_prefix="repo/linux-yarn",outcome="exact",le="2.5"} 0` + "\n",
+		`s3cache_operation_duration_seconds_bucket{action="get",key_prefix="repo/linux-yarn",outcome="exact",le="5"} 1` + "\n",
+		`s3cache_operation_duration_seconds_bucket{action="get",key_prefix="repo/linux-yarn",outcome="miss",le="0.5"} 1` + "\n",
+		`s3cache_operation_duration_seconds_sum{action="get",key_prefix="repo/linux-yarn",outcome="exact"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, metricUploadBytes) {
+		t.Errorf("get must not report uploaded bytes:\n%s", out)
+	}
+}
+
+func TestMetricSetNamedCache(t *testing.T) {
+	m := newMetricSet()
+	m.observe(PutAction, CacheReport{Name: `go "mod"`, Prefix: "repo/", Outcome: OutcomeSaved, ArchiveSize: 10, UncompressedSize: 30})
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	want := `s3cache_uploaded_bytes_total{action="put",key_prefix="repo/",cache="go \"mod\""} 10`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, b.String())
+	}
+}
+
+func TestWriteTextfileAccumulates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "textfile", "s3cache.prom")
+	for run := 1; run <= 2; run++ {
+		m := newMetricSet()
+		m.observe(GetAction, getReport())
+		m.set(metricLastRun, []string{"action", GetAction}, float64(run))
+		if err := m.WriteTextfile(path); err != nil {
+			t.Fatalf("run %d: WriteTextfile failed: %v", run, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read textfile: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`s3cache_operations_total{action="get",key_prefix="repo/linux-yarn",outcome="exact"} 2` + "\n",
+		`s3cache_downloaded_bytes_total{action="get",key_prefix="repo/linux-yarn"} 2000` + "\n",
+		`s3cache_operation_duration_seconds_count{action="get",key_prefix="repo/linux-yarn",outcome="exact"} 2` + "\n",
+		`s3cache_last_run_timestamp_seconds{action="get"} 2` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "# TYPE s3cache_operations_total"); n != 1 {
+		t.Errorf("expected one TYPE line per metric, got %d", n)
+	}
+
+	// The temporary file used for the atomic replace must not be left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the textfile, got %d entries", len(entries))
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, contentType, body = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(data)
+	}))
+	defer server.Close()
+
+	m := newMetricSet()
+	m.observe(GetAction, getReport())
+	if err := m.Push(context.Background(), server.URL+"/", "ci cache", "1234-1-runner 7"); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/ci%20cache/instance/1234-1-runner%207" {
+		t.Errorf("unexpected request %s %s", method, path)
+	}
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	want := `s3cache_operations_total{action="get",key_prefix="repo/linux-yarn",outcome="exact"} 1`
+	if !strings.Contains(body, want) {
+		t.Errorf("missing %q in body:\n%s", want, body)
+	}
+
+	// Values with a slash cannot be path segments and use the base64 form
+	if err := m.Push(context.Background(), server.URL, "s3_cache", "org/runner"); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if path != "/metrics/job/s3_cache/instance@base64/b3JnL3J1bm5lcg" {
+		t.Errorf("unexpected path %s", path)
+	}
+}
+
+func TestDefaultMetricsInstance(t *testing.T) {
+	t.Setenv("GITHUB_REPOSITORY", "acme/web")
+	t.Setenv("GITHUB_RUN_ID", "1234")
+	t.Setenv("RUNNER_NAME", "runner-7")
+	os.Unsetenv("KEY_PREFIX")
+	// Every run of a repository pushes to the same group, so series do not pile up
+	if got := defaultMetricsInstance(); got != "acme/web" {
+		t.Errorf("expected the repository key prefix, got %q", got)
+	}
+	t.Setenv("KEY_PREFIX", "team/cache/")
+	if got := defaultMetricsInstance(); got != "team/cache" {
+		t.Errorf("expected the configured key prefix, got %q", got)
+	}
+
+	t.Setenv("METRICS_INSTANCE", "nightly")
+	if cfg := ParseMetrics(&configProblems{}); cfg.Instance != "nightly" {
+		t.Errorf("expected METRICS_INSTANCE to override, got %q", cfg.Instance)
+	}
+}
+
+func TestMetricsPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		action Action
+		want   string
+	}{
+		{"restore key", Action{KeyPrefix: "repo/", RestoreKeys: []string{"repo/linux-yarn"}}, "repo/linux-yarn"},
+		{"empty default key", Action{KeyPrefix: "repo/", RestoreKeys: []string{"repo/"}}, "repo/"},
+		{"no restore keys", Action{KeyPrefix: "repo/"}, "repo/"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := metricsPrefix(tc.action); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseMetrics(t *testing.T) {
+	t.Setenv("METRICS_TEXTFILE", "")
+	t.Setenv("METRICS_JOB", "")
+	t.Setenv("METRICS_PUSHGATEWAY_URL", "pushgateway:9091")
+
+	p := &configProblems{}
+	cfg := ParseMetrics(p)
+	if len(p.errors) != 1 || cfg.PushgatewayURL != "" {
+		t.Errorf("expected an invalid URL error, got %v, url %q", p.errors, cfg.PushgatewayURL)
+	}
+	if cfg.Job != defaultMetricsJob {
+		t.Errorf("expected default job, got %q", cfg.Job)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Step outputs written by get
+const (
+	OutputCacheHit        = "cache-hit"
+	OutputCacheMatchedKey = "cache-matched-key"
+	OutputCachePrimaryKey = "cache-primary-key"
+)
+
+// SetOutput appends a step output to the file named by GITHUB_OUTPUT.
+// Outside of GitHub Actions, where the variable is unset, the output is only logged.
+func SetOutput(name string, value string) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		slog.Debug("GITHUB_OUTPUT not set, skipping output", "name", name, "value", value)
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer f.Close()
+
+	line := name + "=" + value + "\n"
+	if strings.ContainsAny(value, "\r\n") {
+		// Multiline values use the heredoc form with a delimiter that cannot occur in the value
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		delimiter := "ghadelimiter_" + hex.EncodeToString(buf)
+		line = name + "<<" + delimiter + "\n" + value + "\n" + delimiter + "\n"
+	}
+	if _, err := f.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write output %q: %w", name, err)
+	}
+	return nil
+}
+
+// GetResult - Outcome of restoring one cache.
+// Hit is only true for an exact match of the primary key, as with actions/cache.
+type GetResult struct {
+	PrimaryKey string `json:"cache-primary-key"`
+	MatchedKey string `json:"cache-matched-key"`
+	Hit        bool   `json:"cache-hit"`
+}
+
+// setGetOutputs reports the result of a restore as step outputs.
+func setGetOutputs(r GetResult) {
+	outputs := [][2]string{
+		{OutputCacheHit, fmt.Sprint(r.Hit)},
+		{OutputCachePrimaryKey, r.PrimaryKey},
+		{OutputCacheMatchedKey, r.MatchedKey},
+	}
+	for _, o := range outputs {
+		if err := SetOutput(o[0], o[1]); err != nil {
+			slog.Warn("failed to set output", "name", o[0], "error", err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output")
+	t.Setenv("GITHUB_OUTPUT", path)
+
+	if err := SetOutput(OutputCacheHit, "true"); err != nil {
+		t.Fatalf("SetOutput failed: %v", err)
+	}
+	if err := SetOutput("notes", "line one\nline two"); err != nil {
+		t.Fatalf("SetOutput failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("unexpected output file:\n%s", data)
+	}
+	if lines[0] != "cache-hit=true" {
+		t.Errorf("first output = %q, want %q", lines[0], "cache-hit=true")
+	}
+	delimiter, ok := strings.CutPrefix(lines[1], "notes<<")
+	if !ok || lines[4] != delimiter || lines[2] != "line one" || lines[3] != "line two" {
+		t.Errorf("multiline output not in heredoc form:\n%s", data)
+	}
+}
+
+func TestSetOutputWithoutGitHubOutput(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", "")
+	if err := SetOutput(OutputCacheHit, "false"); err != nil {
+		t.Errorf("SetOutput without GITHUB_OUTPUT should be a no-op, got %v", err)
+	}
+}
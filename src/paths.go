@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// homeMarker is the leading component of archived names stored relative to the home directory.
+const homeMarker = "~"
+
+type (
+	// PathRewrite - Maps the leading path From, as a path would be stored without rewrites,
+	// to the leading path To stored in the archive. Extraction maps To back to From, so the
+	// same rules serve put and get. Both are forward-slash paths and only match whole path
+	// components.
+	PathRewrite struct {
+		From string
+		To   string
+	}
+
+	// PathRewrites - Ordered prefix-rewrite rules. The first matching rule wins, in both directions.
+	PathRewrites []PathRewrite
+)
+
+// ParsePathRewrites parses one "from=to" rule per line. Blank lines are ignored.
+func ParsePathRewrites(value string) (PathRewrites, error) {
+	var rules PathRewrites
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(line, "=")
+		from, to = cleanRewritePath(from), cleanRewritePath(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid path rewrite %q: expected from=to", line)
+		}
+		rules = append(rules, PathRewrite{From: from, To: to})
+	}
+	return rules, nil
+}
+
+// cleanRewritePath normalizes a rule path so "./dir/" and "dir" match the same names, and
+// a path with a Windows volume matches its stored form ("/C:/tools").
+func cleanRewritePath(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return ""
+	}
+	if filepath.VolumeName(p) != "" {
+		p = "/" + p
+	}
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// apply maps a path to its archived name with the first rule whose From is the path or
+// one of its parent directories.
+func (r PathRewrites) apply(name string) string {
+	for _, rule := range r {
+		if rewritten, ok := replacePathPrefix(name, rule.From, rule.To); ok {
+			return rewritten
+		}
+	}
+	return name
+}
+
+// restore maps an archived name back to its path with the first rule whose To is the name
+// or one of its parent directories, reversing apply.
+func (r PathRewrites) restore(name string) string {
+	for _, rule := range r {
+		if rewritten, ok := replacePathPrefix(name, rule.To, rule.From); ok {
+			return rewritten
+		}
+	}
+	return name
+}
+
+// replacePathPrefix replaces the leading path from of name with to, matching whole components.
+func replacePathPrefix(name string, from string, to string) (string, bool) {
+	clean := path.Clean(name)
+	switch {
+	case from == ".":
+		return path.Join(to, clean), true
+	case clean == from:
+		return to, true
+	case from == "/" && strings.HasPrefix(clean, "/"):
+		return path.Join(to, clean[1:]), true
+	}
+	if rest, ok := strings.CutPrefix(clean, from+"/"); ok {
+		return path.Join(to, rest), true
+	}
+	return name, false
+}
+
+// rewritePaths applies the rules to every manifest entry, changing the names stored in the archive.
+func (m *Manifest) rewritePaths(rules PathRewrites) {
+	if len(rules) == 0 {
+		return
+	}
+	for i := range m.Files {
+		m.Files[i].Path = rules.apply(m.Files[i].Path)
+	}
+}
+
+// extractTarget maps a name stored in the archive to the path it is extracted to: the
+// rewrite rules are reversed first, then the name is decoded for this runner and placed
+// under the restore root. With a restore root, absolute and home directory names are
+// restored beneath it and no name may escape it.
+func (ac ArchiveConfig) extractTarget(name string) (string, error) {
+	name = ac.Rewrites.restore(name)
+	if runtime.GOOS == "windows" {
+		if problem := windowsNameProblem(name); problem != "" {
+			return "", fmt.Errorf("%s cannot be restored on Windows: %s", name, problem)
+		}
+	}
+	target, err := decodeArchivePath(name)
+	if err != nil {
+		return "", err
+	}
+	if ac.RestoreRoot == "" {
+		return target, nil
+	}
+	rel := strings.TrimLeft(target[len(filepath.VolumeName(target)):], `/\`)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("archived path %s escapes the restore root", name)
+	}
+	return filepath.Join(ac.RestoreRoot, rel), nil
+}
+
+// expandPattern expands environment variables and a leading ~ in an artifact pattern.
+func expandPattern(pattern string) (string, error) {
+	pattern = os.ExpandEnv(pattern)
+	if pattern != homeMarker && !strings.HasPrefix(pattern, "~/") && !strings.HasPrefix(pattern, `~\`) {
+		return pattern, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to expand %s: %w", pattern, err)
+	}
+	return filepath.Join(home, pattern[1:]), nil
+}
+
+// pathEncoder turns walked paths into portable archive names. Paths inside the workspace
+// are stored relative to it, paths in the home directory as "~/rest", and any other
+// absolute path as a rooted slash path, with a Windows volume as its first component
+// ("/C:/tools"). Relative paths are stored as given.
+type pathEncoder struct {
+	workspace string
+	home      string
+}
+
+// newPathEncoder encodes relative to the working directory and the current user's home.
+func newPathEncoder() pathEncoder {
+	workspace, _ := os.Getwd()
+	home, _ := os.UserHomeDir()
+	return pathEncoder{workspace: workspace, home: home}
+}
+
+func (e pathEncoder) encode(file string) string {
+	if !filepath.IsAbs(file) {
+		return filepath.ToSlash(file)
+	}
+	// The workspace usually lies inside the home directory, so it is tried first
+	if rel, ok := pathWithin(e.workspace, file); ok {
+		return rel
+	}
+	if rel, ok := pathWithin(e.home, file); ok {
+		return path.Join(homeMarker, rel)
+	}
+	return "/" + strings.TrimPrefix(filepath.ToSlash(file), "/")
+}
+
+// pathWithin returns file relative to base as a slash path if it lies inside base.
+func pathWithin(base string, file string) (string, bool) {
+	if base == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(base, file)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// decodeArchivePath reverses pathEncoder.encode for the current runner: "~" names are
+// placed in this user's home directory and rooted names become absolute paths.
+func decodeArchivePath(name string) (string, error) {
+	switch {
+	case name == homeMarker || strings.HasPrefix(name, homeMarker+"/"):
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+		return filepath.Join(home, filepath.FromSlash(name[len(homeMarker):])), nil
+	case strings.HasPrefix(name, "/"):
+		// A leading volume component is only meaningful on Windows, elsewhere it stays a directory name
+		if rest := filepath.FromSlash(name[1:]); filepath.VolumeName(rest) != "" {
+			return rest, nil
+		}
+		return filepath.FromSlash(name), nil
+	default:
+		return filepath.FromSlash(name), nil
+	}
+}
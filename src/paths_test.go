@@ -0,0 +1,133 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePathRewrites(t *testing.T) {
+	rules, err := ParsePathRewrites("\n./build/=out\n/home/alice/.cache = /home/bob/.cache\n")
+	if err != nil {
+		t.Fatalf("ParsePathRewrites failed: %v", err)
+	}
+	want := PathRewrites{{From: "build", To: "out"}, {From: "/home/alice/.cache", To: "/home/bob/.cache"}}
+	if len(rules) != len(want) || rules[0] != want[0] || rules[1] != want[1] {
+		t.Errorf("expected %v, got %v", want, rules)
+	}
+
+	for _, bad := range []string{"build", "=out", "build="} {
+		if _, err := ParsePathRewrites(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestPathRewritesApply(t *testing.T) {
+	rules := PathRewrites{
+		{From: "/home/runner/work/a/a", To: "/src"},
+		{From: "build", To: "out/build"},
+		{From: "build/cache", To: "never"}, // shadowed by the rule above
+	}
+	tests := map[string]string{
+		"/home/runner/work/a/a":          "/src",
+		"/home/runner/work/a/a/pkg/x.go": "/src/pkg/x.go",
+		"/home/runner/work/a/ab/x.go":    "/home/runner/work/a/ab/x.go",
+		"build/cache/obj":                "out/build/cache/obj",
+		"./build/y":                      "out/build/y",
+		"builds/y":                       "builds/y",
+		"node_modules/.bin/tsc":          "node_modules/.bin/tsc",
+	}
+	for name, want := range tests {
+		if got := rules.apply(name); got != want {
+			t.Errorf("apply(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPathRewritesRestore(t *testing.T) {
+	rules := PathRewrites{
+		{From: "checkout", To: "workspace"},
+		{From: "/opt/tools", To: "tools"},
+	}
+	for _, name := range []string{"checkout/deps/lib.txt", "/opt/tools/bin/x", "other/file"} {
+		if got := rules.restore(rules.apply(name)); got != name {
+			t.Errorf("restore(apply(%q)) = %q, want the original path", name, got)
+		}
+	}
+	if got := rules.restore("workspace"); got != "checkout" {
+		t.Errorf("restore(workspace) = %q, want checkout", got)
+	}
+}
+
+func TestExtractTarget(t *testing.T) {
+	root := t.TempDir()
+	ac := ArchiveConfig{RestoreRoot: root, Rewrites: PathRewrites{{From: "home", To: "/home/alice"}}}
+
+	tests := map[string]string{
+		"a/b.txt":              filepath.Join(root, "a", "b.txt"),
+		"/opt/tool/bin":        filepath.Join(root, "opt", "tool", "bin"),
+		"/home/alice/.cache/x": filepath.Join(root, "home", ".cache", "x"),
+	}
+	for name, want := range tests {
+		got, err := ac.extractTarget(name)
+		if err != nil || got != want {
+			t.Errorf("extractTarget(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	if _, err := ac.extractTarget("../escape"); err == nil {
+		t.Error("expected a path escaping the restore root to be rejected")
+	}
+}
+
+func TestExpandPattern(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TOOL_DIR", "/opt/hostedtoolcache")
+
+	tests := map[string]string{
+		"~":                 home,
+		"~/.m2/repository":  filepath.Join(home, ".m2", "repository"),
+		"$TOOL_DIR/foo":     "/opt/hostedtoolcache/foo",
+		"${TOOL_DIR}/*/bin": "/opt/hostedtoolcache/*/bin",
+		"node_modules/~/x":  "node_modules/~/x",
+		"~other/.cache":     "~other/.cache",
+	}
+	for pattern, want := range tests {
+		if got, err := expandPattern(pattern); err != nil || got != want {
+			t.Errorf("expandPattern(%q) = %q, %v, want %q", pattern, got, err, want)
+		}
+	}
+}
+
+func TestPathEncoder(t *testing.T) {
+	e := pathEncoder{workspace: "/home/runner/work/a/a", home: "/home/runner"}
+	tests := map[string]string{
+		"node_modules/x":                  "node_modules/x",
+		"/home/runner/work/a/a/build/out": "build/out",
+		"/home/runner/.m2/repository":     "~/.m2/repository",
+		"/home/runner":                    "~",
+		"/opt/hostedtoolcache/foo":        "/opt/hostedtoolcache/foo",
+	}
+	for file, want := range tests {
+		if got := e.encode(file); got != want {
+			t.Errorf("encode(%q) = %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestDecodeArchivePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	tests := map[string]string{
+		"build/out":                filepath.Join("build", "out"),
+		"~/.m2/repository":         filepath.Join(home, ".m2", "repository"),
+		"/opt/hostedtoolcache/foo": filepath.FromSlash("/opt/hostedtoolcache/foo"),
+	}
+	for name, want := range tests {
+		if got, err := decodeArchivePath(name); err != nil || got != want {
+			t.Errorf("decodeArchivePath(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProgressInterval is how often long transfers log their progress.
+const defaultProgressInterval = 10 * time.Second
+
+// progressInterval is the time between progress events, set via SetProgressInterval.
+var progressInterval atomic.Int64
+
+func init() {
+	progressInterval.Store(int64(defaultProgressInterval))
+}
+
+// SetProgressInterval sets the time between progress events. Zero restores the default.
+func SetProgressInterval(d time.Duration) {
+	if d <= 0 {
+		d = defaultProgressInterval
+	}
+	progressInterval.Store(int64(d))
+}
+
+// progress - Throttled progress logging for one long-running operation. Counting is
+// safe for concurrent use, so parallel part downloads can share a progress.
+type progress struct {
+	operation string // "upload", "download", "archive" or "extract"
+	key       string
+	total     int64 // 0 if unknown, in which case no percentage or ETA is logged
+	start     time.Time
+	interval  time.Duration
+	done      atomic.Int64
+	next      atomic.Int64 // unix nanoseconds before which nothing is logged
+}
+
+func newProgress(operation string, key string, total int64) *progress {
+	p := &progress{
+		operation: operation,
+		key:       key,
+		total:     total,
+		start:     time.Now(),
+		interval:  time.Duration(progressInterval.Load()),
+	}
+	// Operations finishing within one interval never log progress
+	p.next.Store(p.start.Add(p.interval).UnixNano())
+	return p
+}
+
+// add counts n more bytes done and logs if the interval has passed since the last event.
+func (p *progress) add(n int) {
+	done := p.done.Add(int64(n))
+	now := time.Now()
+	next := p.next.Load()
+	if now.UnixNano() < next || !p.next.CompareAndSwap(next, now.Add(p.interval).UnixNano()) {
+		return
+	}
+	slog.Info(p.operation+" progress", p.attrs(done, now.Sub(p.start))...)
+}
+
+// attrs describes the progress as plain key/value pairs, rendered by both the text and JSON handlers.
+func (p *progress) attrs(done int64, elapsed time.Duration) []any {
+	attrs := []any{"done", getReadableBytes(done)}
+	if p.key != "" {
+		attrs = append([]any{"key", p.key}, attrs...)
+	}
+	speed := float64(done) / elapsed.Seconds() / 1024 / 1024 // MB/s
+	if p.total > 0 {
+		attrs = append(attrs, "total", getReadableBytes(p.total),
+			"percent", math.Round(float64(done)/float64(p.total)*1000)/10)
+	}
+	attrs = append(attrs, "speed_mbps", math.Round(speed*10)/10)
+	if p.total > done && done > 0 {
+		remaining := time.Duration(float64(elapsed) * float64(p.total-done) / float64(done))
+		attrs = append(attrs, "eta", remaining.Round(time.Second))
+	}
+	return attrs
+}
+
+// progressReader counts the bytes read through it.
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(n)
+	return n, err
+}
+
+// progressWriter counts the bytes written through it.
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.p.add(n)
+	return n, err
+}
+
+// progressWriterAt counts the bytes written through it by concurrent part downloads.
+type progressWriterAt struct {
+	w io.WriterAt
+	p *progress
+}
+
+func (w *progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := w.w.WriteAt(b, off)
+	w.p.add(n)
+	return n, err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs routes the default logger to a JSON handler for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestProgressEvents(t *testing.T) {
+	logs := captureLogs(t)
+	p := newProgress("download", "linux-yarn.tar.zst", 1000)
+	p.interval = time.Hour
+
+	// Nothing is logged until the interval has passed
+	p.add(100)
+	if logs.Len() != 0 {
+		t.Fatalf("expected no progress before the interval, got %s", logs)
+	}
+
+	p.start = time.Now().Add(-2 * time.Second)
+	p.next.Store(0)
+	p.add(150)
+	p.add(50) // throttled again until the next interval
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one progress event, got %d:\n%s", len(lines), logs)
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("invalid JSON log line: %v", err)
+	}
+	if event["msg"] != "download progress" || event["key"] != "linux-yarn.tar.zst" || event["percent"] != 25.0 {
+		t.Errorf("unexpected event %v", event)
+	}
+	for _, field := range []string{"done", "total", "speed_mbps", "eta"} {
+		if _, ok := event[field]; !ok {
+			t.Errorf("missing %q in %v", field, event)
+		}
+	}
+}
+
+func TestProgressUnknownTotal(t *testing.T) {
+	p := newProgress("upload", "", 0)
+	attrs := p.attrs(5<<20, time.Second)
+	for i := 0; i < len(attrs); i += 2 {
+		switch attrs[i] {
+		case "total", "percent", "eta", "key":
+			t.Errorf("unexpected attribute %v without a total or key", attrs[i])
+		}
+	}
+}
+
+func TestProgressReaderCounts(t *testing.T) {
+	p := newProgress("extract", "", 0)
+	r := &progressReader{r: strings.NewReader(strings.Repeat("x", 4096)), p: p}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if p.done.Load() != 4096 {
+		t.Errorf("expected 4096 bytes counted, got %d", p.done.Load())
+	}
+}
+
+func TestSetProgressInterval(t *testing.T) {
+	defer SetProgressInterval(0)
+	SetProgressInterval(time.Minute)
+	if got := newProgress("upload", "", 0).interval; got != time.Minute {
+		t.Errorf("expected 1m interval, got %v", got)
+	}
+	SetProgressInterval(0)
+	if got := newProgress("upload", "", 0).interval; got != defaultProgressInterval {
+		t.Errorf("expected default interval, got %v", got)
+	}
+}
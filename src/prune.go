@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// Reasons a cache is selected for pruning
+	PruneReasonMaxAge     = "max-age"
+	PruneReasonKeepNewest = "keep-newest"
+	PruneReasonMaxSize    = "max-total-size"
+
+	// defaultPruneGroupDelimiter splits "<default-key>-<hash>" style keys into their group
+	defaultPruneGroupDelimiter = "-"
+)
+
+type (
+	// PrunePolicy - Rules deciding which caches under a prefix are removed.
+	// Zero values disable the corresponding rule.
+	PrunePolicy struct {
+		MaxAge         time.Duration // remove caches not used for longer than this
+		MaxTotalSize   int64         // evict least recently used caches until the total fits
+		KeepNewest     int           // keep only the N newest caches per group
+		GroupDelimiter string        // a key's group is everything before the last delimiter
+	}
+
+	// PruneDecision - A cache selected for removal and why
+	PruneDecision struct {
+		Cache  CacheObject
+		Reason string
+	}
+)
+
+// enabled reports whether at least one pruning rule is configured.
+func (p PrunePolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxTotalSize > 0 || p.KeepNewest > 0
+}
+
+// groupOf returns the group a cache key belongs to: the key without its extension,
+// truncated at the last group delimiter. "linux-yarn-abc.tar.zst" belongs to "linux-yarn".
+// Only the last path segment is searched, so a delimiter inside the key prefix or scope
+// namespace ("acme/web-app/yarn.tar.zst") never merges different caches into one group.
+// A delimiter containing "/" splits the key on path components instead.
+func (p PrunePolicy) groupOf(key string) string {
+	delim := p.GroupDelimiter
+	if delim == "" {
+		delim = defaultPruneGroupDelimiter
+	}
+	base := strings.TrimSuffix(key, keyExtension(compressionFromKey(key)))
+	start := 0
+	if !strings.Contains(delim, "/") {
+		start = strings.LastIndex(base, "/") + 1
+	}
+	if i := strings.LastIndex(base[start:], delim); i > 0 {
+		return base[:start+i]
+	}
+	return base
+}
+
+// planPrune selects the caches to delete under the given policy.
+// Rules are applied in order: keep-newest per group, max age, then max total size
+// over whatever remains, evicting the least recently used caches first.
+func planPrune(caches []CacheObject, policy PrunePolicy, now time.Time) []PruneDecision {
+	var decisions []PruneDecision
+	removed := make(map[string]bool)
+
+	if policy.KeepNewest > 0 {
+		groups := make(map[string][]CacheObject)
+		for _, c := range caches {
+			g := policy.groupOf(c.Key)
+			groups[g] = append(groups[g], c)
+		}
+		for _, members := range groups {
+			sort.Slice(members, func(i, j int) bool {
+				return members[i].LastModified.After(members[j].LastModified)
+			})
+			for _, c := range members[min(policy.KeepNewest, len(members)):] {
+				decisions = append(decisions, PruneDecision{Cache: c, Reason: PruneReasonKeepNewest})
+				removed[c.Key] = true
+			}
+		}
+	}
+
+	if policy.MaxAge > 0 {
+		cutoff := now.Add(-policy.MaxAge)
+		for _, c := range caches {
+			if !removed[c.Key] && c.lastUsed().Before(cutoff) {
+				decisions = append(decisions, PruneDecision{Cache: c, Reason: PruneReasonMaxAge})
+				removed[c.Key] = true
+			}
+		}
+	}
+
+	if policy.MaxTotalSize > 0 {
+		var remaining []CacheObject
+		var total int64
+		for _, c := range caches {
+			if !removed[c.Key] {
+				remaining = append(remaining, c)
+				total += c.Size
+			}
+		}
+		sort.Slice(remaining, func(i, j int) bool {
+			return remaining[i].lastUsed().Before(remaining[j].lastUsed())
+		})
+		for _, c := range remaining {
+			if total <= policy.MaxTotalSize {
+				break
+			}
+			decisions = append(decisions, PruneDecision{Cache: c, Reason: PruneReasonMaxSize})
+			removed[c.Key] = true
+			total -= c.Size
+		}
+	}
+
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].Cache.Key < decisions[j].Cache.Key
+	})
+	return decisions
+}
+
+// runPrune enforces the configured prune policy on every cache under the prefix.
+// In dry-run mode the report is printed but nothing is deleted.
+func runPrune(action Action) error {
+	if !action.Prune.enabled() {
+		return fmt.Errorf("no prune policy configured, set at least one of max-age, max-total-size or keep-newest")
+	}
+
+	caches, err := listScopedCaches(action)
+	if err != nil {
+		return fmt.Errorf("failed to list caches: %w", err)
+	}
+
+	decisions := planPrune(caches, action.Prune, time.Now())
+
+	var freed int64
+	keys := make([]string, 0, len(decisions)*4)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSIZE\tAGE\tLAST USED\tREASON")
+	for _, d := range decisions {
+		freed += d.Cache.Size
+		keys = append(keys, d.Cache.Key)
+		keys = append(keys, sidecarKeys(d.Cache.Key)...)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			d.Cache.Key, getReadableBytes(d.Cache.Size), getReadableAge(time.Since(d.Cache.LastModified)),
+			getReadableAge(time.Since(d.Cache.lastUsed())), d.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if action.DryRun {
+		slog.Info("dry run, no caches deleted",
+			"prefix", action.Prefix, "scanned", len(caches), "would_delete", len(decisions), "would_free", getReadableBytes(freed))
+		return nil
+	}
+
+	switch len(decisions) {
+	case 0:
+	case 1:
+		if err := DeleteObject(decisions[0].Cache.Key, action.Bucket); err != nil {
+			return fmt.Errorf("failed to delete cache: %w", err)
+		}
+	default:
+		if err := DeleteObjects(keys, action.Bucket); err != nil {
+			return fmt.Errorf("failed to delete caches: %w", err)
+		}
+	}
+
+	slog.Info("pruned caches",
+		"prefix", action.Prefix, "scanned", len(caches), "deleted", len(decisions), "freed", getReadableBytes(freed))
+	return nil
+}
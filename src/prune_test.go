@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrunePolicyGroupOf(t *testing.T) {
+	tests := []struct {
+		key       string
+		delimiter string
+		expected  string
+	}{
+		{"linux-yarn-abc123.tar.zst", "", "linux-yarn"},
+		{"linux-yarn-abc123.tar", "", "linux-yarn"},
+		{"linux-yarn-v2-abc123.tar.zst", "", "linux-yarn-v2"},
+		{"nodash.tar.zst", "", "nodash"},
+		{"team/linux/yarn.tar.zst", "/", "team/linux"},
+		{"acme/web-app/gradle.tar.zst", "", "acme/web-app/gradle"},
+		{"acme/web-app/yarn.tar.zst", "", "acme/web-app/yarn"},
+		{"acme/web-app/heads/feature-x/linux-yarn-abc123.tar.zst", "", "acme/web-app/heads/feature-x/linux-yarn"},
+	}
+	for _, tt := range tests {
+		result := PrunePolicy{GroupDelimiter: tt.delimiter}.groupOf(tt.key)
+		if result != tt.expected {
+			t.Errorf("groupOf(%q, %q) = %q, want %q", tt.key, tt.delimiter, result, tt.expected)
+		}
+	}
+}
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	caches := []CacheObject{
+		{Key: "linux-yarn-a.tar.zst", Size: 100, LastModified: now.Add(-1 * day)},
+		{Key: "linux-yarn-b.tar.zst", Size: 100, LastModified: now.Add(-2 * day)},
+		{Key: "linux-yarn-c.tar.zst", Size: 100, LastModified: now.Add(-3 * day)},
+		{Key: "linux-gradle-a.tar.zst", Size: 300, LastModified: now.Add(-10 * day)},
+		{Key: "linux-gradle-b.tar.zst", Size: 300, LastModified: now.Add(-40 * day)},
+	}
+
+	reasons := func(decisions []PruneDecision) map[string]string {
+		m := make(map[string]string)
+		for _, d := range decisions {
+			m[d.Cache.Key] = d.Reason
+		}
+		return m
+	}
+
+	t.Run("keep_newest", func(t *testing.T) {
+		got := reasons(planPrune(caches, PrunePolicy{KeepNewest: 1}, now))
+		want := map[string]string{
+			"linux-yarn-b.tar.zst":   PruneReasonKeepNewest,
+			"linux-yarn-c.tar.zst":   PruneReasonKeepNewest,
+			"linux-gradle-b.tar.zst": PruneReasonKeepNewest,
+		}
+		assertPruneReasons(t, got, want)
+	})
+
+	t.Run("max_age", func(t *testing.T) {
+		got := reasons(planPrune(caches, PrunePolicy{MaxAge: 30 * day}, now))
+		assertPruneReasons(t, got, map[string]string{"linux-gradle-b.tar.zst": PruneReasonMaxAge})
+	})
+
+	t.Run("max_total_size_evicts_least_recently_used", func(t *testing.T) {
+		got := reasons(planPrune(caches, PrunePolicy{MaxTotalSize: 400}, now))
+		want := map[string]string{
+			"linux-gradle-b.tar.zst": PruneReasonMaxSize,
+			"linux-gradle-a.tar.zst": PruneReasonMaxSize,
+		}
+		assertPruneReasons(t, got, want)
+	})
+
+	t.Run("recent_access_protects_old_cache", func(t *testing.T) {
+		accessed := append([]CacheObject(nil), caches...)
+		accessed[4].LastAccessed = now.Add(-time.Hour) // linux-gradle-b restored an hour ago
+		got := reasons(planPrune(accessed, PrunePolicy{MaxAge: 30 * day, MaxTotalSize: 400}, now))
+		want := map[string]string{
+			"linux-gradle-a.tar.zst": PruneReasonMaxSize,
+			"linux-yarn-c.tar.zst":   PruneReasonMaxSize,
+			"linux-yarn-b.tar.zst":   PruneReasonMaxSize,
+		}
+		assertPruneReasons(t, got, want)
+	})
+
+	t.Run("combined_rules_do_not_double_count", func(t *testing.T) {
+		got := reasons(planPrune(caches, PrunePolicy{KeepNewest: 2, MaxAge: 30 * day, MaxTotalSize: 200}, now))
+		want := map[string]string{
+			"linux-yarn-c.tar.zst":   PruneReasonKeepNewest,
+			"linux-gradle-b.tar.zst": PruneReasonMaxAge,
+			"linux-gradle-a.tar.zst": PruneReasonMaxSize,
+		}
+		assertPruneReasons(t, got, want)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		if (PrunePolicy{}).enabled() {
+			t.Fatal("empty policy should be disabled")
+		}
+		if got := planPrune(caches, PrunePolicy{}, now); len(got) != 0 {
+			t.Errorf("expected no decisions, got %v", got)
+		}
+	})
+}
+
+func TestAccessKey(t *testing.T) {
+	key := "linux-yarn-abc.tar.zst"
+	if !isAccessKey(accessKey(key)) {
+		t.Error("expected access marker key to be detected")
+	}
+	if isAccessKey(key) || compressionFromKey(accessKey(key)) != "" {
+		t.Error("access marker must not be mistaken for a cache archive")
+	}
+}
+
+func assertPruneReasons(t *testing.T, got, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %d decisions %v, want %d %v", len(got), got, len(want), want)
+	}
+	for key, reason := range want {
+		if got[key] != reason {
+			t.Errorf("decision for %s = %q, want %q", key, got[key], reason)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// readAheadFileSize is the largest file read into memory ahead of the tar writer.
+	// Larger files are only opened ahead and streamed by the writer.
+	readAheadFileSize = 1024 * 1024
+
+	// readAheadWindow is the number of files in flight per worker
+	readAheadWindow = 2
+)
+
+type (
+	// readAhead - Opens and reads manifest files on a pool of workers ahead of the tar writer
+	// and hands them back in manifest order, so the archive does not depend on which worker
+	// finishes first. At most readAheadWindow files per worker are in flight and only files
+	// up to readAheadFileSize are buffered, which bounds memory regardless of file sizes.
+	readAhead struct {
+		queue chan *prefetchedFile
+		stop  chan struct{}
+		once  sync.Once
+	}
+
+	// prefetchedFile - A file opened, and if small enough read, by a read-ahead worker
+	prefetchedFile struct {
+		entry ManifestEntry
+		file  *os.File // open file of a large entry
+		data  []byte   // contents of a small entry
+		err   error
+		done  chan struct{} // closed once the worker is finished with the file
+	}
+)
+
+// newReadAhead starts reading the regular files of entries with the given number of workers.
+// The caller must take every file with next, or call close to stop early.
+func newReadAhead(entries []ManifestEntry, workers int) *readAhead {
+	if workers <= 0 {
+		workers = defaultConcurrency
+	}
+	workers = min(workers, maxConcurrency)
+	r := &readAhead{
+		queue: make(chan *prefetchedFile, workers*readAheadWindow),
+		stop:  make(chan struct{}),
+	}
+	jobs := make(chan *prefetchedFile)
+
+	go func() {
+		defer close(r.queue)
+		defer close(jobs)
+		for _, entry := range entries {
+			if entry.Type != EntryTypeFile {
+				continue
+			}
+			f := &prefetchedFile{entry: entry, done: make(chan struct{})}
+			// Queueing first blocks here once the window is full
+			select {
+			case r.queue <- f:
+			case <-r.stop:
+				return
+			}
+			select {
+			case jobs <- f:
+			case <-r.stop:
+				close(f.done)
+				return
+			}
+		}
+	}()
+
+	for range workers {
+		go func() {
+			for f := range jobs {
+				f.load()
+				close(f.done)
+			}
+		}()
+	}
+	return r
+}
+
+// next returns the next regular file in manifest order, waiting for its worker if needed.
+func (r *readAhead) next() *prefetchedFile {
+	f, ok := <-r.queue
+	if !ok {
+		return &prefetchedFile{err: io.ErrUnexpectedEOF}
+	}
+	<-f.done
+	return f
+}
+
+// close stops reading ahead and closes the files that were opened but not taken.
+func (r *readAhead) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		for f := range r.queue {
+			<-f.done
+			f.Close()
+		}
+	})
+}
+
+// load opens the file and reads it whole if it is small.
+func (f *prefetchedFile) load() {
+	file, err := os.Open(f.entry.source)
+	if err != nil {
+		f.err = err
+		return
+	}
+	if f.entry.Size > readAheadFileSize {
+		f.file = file
+		return
+	}
+	defer file.Close()
+	// A file that grew since the manifest was built fails the hash check while archiving
+	f.data, f.err = io.ReadAll(io.LimitReader(file, readAheadFileSize+1))
+}
+
+// reader returns the file contents.
+func (f *prefetchedFile) reader() io.Reader {
+	if f.file != nil {
+		return f.file
+	}
+	return bytes.NewReader(f.data)
+}
+
+// Close releases the open file of a large entry.
+func (f *prefetchedFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readAheadEntries writes n files, every fifth one larger than readAheadFileSize.
+func readAheadEntries(t *testing.T, n int) []ManifestEntry {
+	t.Helper()
+	dir := t.TempDir()
+	entries := []ManifestEntry{{Path: "dir", Type: EntryTypeDir}}
+	for i := range n {
+		size := 10 + i
+		if i%5 == 0 {
+			size = readAheadFileSize + i
+		}
+		path := filepath.Join(dir, fmt.Sprintf("f%03d", i))
+		if err := os.WriteFile(path, []byte(strings.Repeat(string(rune('a'+i%26)), size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, ManifestEntry{Path: path, Type: EntryTypeFile, Size: int64(size), source: path})
+	}
+	return entries
+}
+
+func TestReadAheadOrder(t *testing.T) {
+	entries := readAheadEntries(t, 40)
+	files := newReadAhead(entries, 4)
+	defer files.close()
+
+	for _, entry := range entries[1:] {
+		f := files.next()
+		if f.err != nil {
+			t.Fatalf("unexpected error for %s: %v", entry.Path, f.err)
+		}
+		if f.entry.Path != entry.Path {
+			t.Fatalf("expected %s, got %s", entry.Path, f.entry.Path)
+		}
+		data, err := io.ReadAll(f.reader())
+		f.Close()
+		if err != nil || int64(len(data)) != entry.Size {
+			t.Fatalf("expected %d bytes of %s, got %d, %v", entry.Size, entry.Path, len(data), err)
+		}
+		if (f.data == nil) != (entry.Size > readAheadFileSize) {
+			t.Errorf("%s: only small files should be buffered", entry.Path)
+		}
+	}
+}
+
+func TestReadAheadCloseEarly(t *testing.T) {
+	entries := readAheadEntries(t, 40)
+	files := newReadAhead(entries, 2)
+	files.next().Close()
+	files.close()
+	files.close() // closing twice is harmless
+}
+
+func TestReadAheadMissingFile(t *testing.T) {
+	entries := []ManifestEntry{{Path: "gone", Type: EntryTypeFile, source: filepath.Join(t.TempDir(), "gone")}}
+	files := newReadAhead(entries, 1)
+	defer files.close()
+	if f := files.next(); !os.IsNotExist(f.err) {
+		t.Errorf("expected a not-exist error, got %v", f.err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"archive/tar"
+	"sort"
+	"time"
+)
+
+// makeReproducible prepares a manifest for an archive that only depends on the archived
+// paths, contents and modes. Entries are sorted by name, and every timestamp, including
+// the manifest's creation time, is clamped to epoch and truncated to whole seconds.
+func (m *Manifest) makeReproducible(epoch time.Time) {
+	m.Reproducible = true
+	epoch = epoch.UTC().Truncate(time.Second)
+	m.CreatedAt = epoch
+	sort.SliceStable(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	for i, f := range m.Files {
+		m.Files[i].ModTime = clampTime(f.ModTime, epoch)
+	}
+}
+
+// clampTime returns t truncated to seconds, or epoch if t is later.
+func clampTime(t time.Time, epoch time.Time) time.Time {
+	if t.After(epoch) {
+		return epoch
+	}
+	return t.UTC().Truncate(time.Second)
+}
+
+// normalizeHeader strips everything from a tar header that varies between runs or runners
+// over identical inputs: owners, access and change times, device numbers and PAX records.
+// The mode and modification time are taken from the reproducible manifest entry.
+func normalizeHeader(header *tar.Header, entry ManifestEntry) {
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.Mode = entry.Mode
+	header.ModTime = entry.ModTime
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.Devmajor, header.Devminor = 0, 0
+	header.PAXRecords = nil
+	header.Format = tar.FormatUnknown
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReproducibleArchive(t *testing.T) {
+	tempDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ac := ArchiveConfig{Compression: CompressionZstd, Reproducible: true, SourceDateEpoch: epoch}
+	// Files are created in a different order, with different timestamps, for each run
+	create := func(names []string, mtime time.Time) {
+		os.RemoveAll("src")
+		for _, name := range names {
+			path := filepath.Join("src", name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			os.WriteFile(path, []byte("contents of "+name), 0644)
+			os.Chtimes(path, mtime, mtime)
+		}
+	}
+
+	create([]string{"b.txt", "a/z.txt", "a/y.txt"}, time.Now())
+	if err := ZipArchive("first.tar.zst", []string{"src"}, ac); err != nil {
+		t.Fatalf("ZipArchive failed: %v", err)
+	}
+	create([]string{"a/y.txt", "a/z.txt", "b.txt"}, time.Now().Add(time.Hour))
+	if err := ZipArchive("second.tar.zst", []string{"src"}, ac); err != nil {
+		t.Fatalf("ZipArchive failed: %v", err)
+	}
+
+	first, _ := os.ReadFile("first.tar.zst")
+	second, _ := os.ReadFile("second.tar.zst")
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected identical archives for identical inputs")
+	}
+
+	var names []string
+	manifest, err := ReadArchiveHeaders(bytes.NewReader(first), ac, func(h *tar.Header) error {
+		names = append(names, h.Name)
+		if h.Uid != 0 || h.Gid != 0 || h.Uname != "" || h.Gname != "" || !h.AccessTime.IsZero() || !h.ModTime.Equal(epoch) {
+			t.Errorf("%s: header not normalized: %+v", h.Name, h)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadArchiveHeaders failed: %v", err)
+	}
+	if !manifest.Reproducible || !manifest.CreatedAt.Equal(epoch) {
+		t.Errorf("expected a reproducible manifest created at the epoch, got %v %v", manifest.Reproducible, manifest.CreatedAt)
+	}
+	want := []string{"src", "src/a", "src/a/y.txt", "src/a/z.txt", "src/b.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, names)
+			break
+		}
+	}
+}
+
+func TestClampTime(t *testing.T) {
+	epoch := time.Unix(1700000000, 0).UTC()
+	older := time.Unix(1600000000, 500).UTC()
+	if got := clampTime(older, epoch); !got.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("expected older times truncated to seconds, got %v", got)
+	}
+	if got := clampTime(epoch.Add(time.Hour), epoch); !got.Equal(epoch) {
+		t.Errorf("expected newer times clamped, got %v", got)
+	}
+}
+
+func TestEpochEnv(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	p := &configProblems{}
+	if got := p.epochEnv("SOURCE_DATE_EPOCH"); !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected epoch %v", got)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if got := p.epochEnv("SOURCE_DATE_EPOCH"); !got.IsZero() || len(p.warnings) != 1 {
+		t.Errorf("expected a warning and the zero time, got %v, %v", got, p.warnings)
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -20,6 +26,9 @@ const (
 	// Default concurrency for uploads/downloads
 	defaultConcurrency = 10
 
+	// maxConcurrency caps configured upload and download concurrency
+	maxConcurrency = 64
+
 	// Part size limits
 	minPartSize = 5 * 1024 * 1024   // 5 MiB minimum (AWS requirement)
 	maxPartSize = 100 * 1024 * 1024 // 100 MiB maximum (practical limit)
@@ -29,6 +38,9 @@ const (
 
 	// Maximum number of parts for multipart upload
 	maxUploadParts = 10000
+
+	// Maximum number of keys per DeleteObjects request
+	maxDeleteBatch = 1000
 )
 
 // TransferConfig holds configurable S3 transfer parameters.
@@ -42,14 +54,14 @@ type TransferConfig struct {
 
 func (tc TransferConfig) uploadConcurrency() int {
 	if tc.UploadConcurrency > 0 {
-		return tc.UploadConcurrency
+		return min(tc.UploadConcurrency, maxConcurrency)
 	}
 	return defaultConcurrency
 }
 
 func (tc TransferConfig) downloadConcurrency() int {
 	if tc.DownloadConcurrency > 0 {
-		return tc.DownloadConcurrency
+		return min(tc.DownloadConcurrency, maxConcurrency)
 	}
 	return defaultConcurrency
 }
@@ -94,9 +106,40 @@ func (tc TransferConfig) resolveStreamUploadPartSize() int64 {
 	return minPartSize
 }
 
-// getS3Client creates a new S3 client with the configured region and optional custom endpoint
-// Supports S3 Transfer Acceleration when S3_USE_ACCELERATE=true
+// The S3 client is created once and shared, so caches processed concurrently reuse its
+// credentials and connection pool.
+var (
+	sharedClientMu sync.Mutex
+	sharedClient   *s3.Client
+)
+
+// getS3Client returns the shared S3 client, creating it on first use.
 func getS3Client(ctx context.Context) (*s3.Client, error) {
+	sharedClientMu.Lock()
+	defer sharedClientMu.Unlock()
+
+	if sharedClient == nil {
+		client, err := newS3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sharedClient = client
+	}
+	return sharedClient, nil
+}
+
+// resetS3Client discards the shared client so the next request picks up changed settings.
+func resetS3Client() {
+	sharedClientMu.Lock()
+	sharedClient = nil
+	sharedClientMu.Unlock()
+}
+
+// newS3Client creates a new S3 client with the configured region and optional custom endpoint
+// Supports S3 Transfer Acceleration when S3_USE_ACCELERATE=true
+// Server-side encryption set via SetServerSideEncryption is applied to every request
+// Part uploads and downloads made within a traced phase are recorded as spans
+func newS3Client(ctx context.Context) (*s3.Client, error) {
 	region := os.Getenv("AWS_REGION")
 	if region == "" {
 		region = "us-east-1"
@@ -107,26 +150,36 @@ func getS3Client(ctx context.Context) (*s3.Client, error) {
 		return nil, err
 	}
 
+	optFns := []func(*s3.Options){func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, traceAPIOption)
+	}}
+	if serverSideEncryption.enabled() {
+		sse := serverSideEncryption
+		optFns = append(optFns, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, sse.apiOption)
+		})
+	}
+
 	// Check for custom endpoint (useful for S3-compatible services like MinIO, LocalStack)
 	customEndpoint := os.Getenv("AWS_S3_ENDPOINT")
 	if customEndpoint != "" {
 		slog.Debug("using custom S3 endpoint", "endpoint", customEndpoint)
-		return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		return s3.NewFromConfig(cfg, append(optFns, func(o *s3.Options) {
 			o.BaseEndpoint = aws.String(customEndpoint)
 			o.UsePathStyle = true // Required for most S3-compatible services
-		}), nil
+		})...), nil
 	}
 
 	// Check for S3 Transfer Acceleration
 	useAccelerate := os.Getenv("S3_USE_ACCELERATE") == "true"
 	if useAccelerate {
 		slog.Debug("S3 Transfer Acceleration enabled")
-		return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		return s3.NewFromConfig(cfg, append(optFns, func(o *s3.Options) {
 			o.UseAccelerate = true
-		}), nil
+		})...), nil
 	}
 
-	return s3.NewFromConfig(cfg), nil
+	return s3.NewFromConfig(cfg, optFns...), nil
 }
 
 // optimalPartSize calculates the optimal part size for multipart uploads
@@ -148,41 +201,88 @@ func optimalPartSize(fileSize int64) int64 {
 	return ((partSize + mib - 1) / mib) * mib
 }
 
+// GetLatestObject returns the key of the most recently written cache whose key starts with the given prefix.
 func GetLatestObject(key string, bucket string) (string, error) {
-	session, err := getS3Client(context.TODO())
-	if err != nil {
-		return "", err
-	}
-
-	response, err := session.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	})
+	files, err := ListCaches(key, bucket)
 	if err != nil {
 		return "", err
 	}
 
-	files := response.Contents
-
 	if len(files) < 1 {
 		return "", errors.New("failed to find any files matching default key")
 	}
 
 	sort.Slice(files, func(i, j int) bool {
-		// Handle nil LastModified pointers safely
-		if files[i].LastModified == nil {
-			return false
+		return files[i].LastModified.After(files[j].LastModified)
+	})
+
+	return files[0].Key, nil
+}
+
+// ListCaches returns every cache archive whose key starts with prefix.
+// Access markers found in the same listing populate LastAccessed.
+// Sidecar manifests and other non-archive objects are skipped.
+func ListCaches(prefix string, bucket string) ([]CacheObject, error) {
+	session, err := getS3Client(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	var caches []CacheObject
+	accessed := make(map[string]time.Time)
+	paginator := s3.NewListObjectsV2Paginator(session, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
 		}
-		if files[j].LastModified == nil {
-			return true
+		for _, obj := range page.Contents {
+			// Sidecar manifests share the key prefix but are not restorable caches
+			if obj.Key == nil || isManifestKey(*obj.Key) {
+				continue
+			}
+			if isAccessKey(*obj.Key) {
+				accessed[strings.TrimSuffix(*obj.Key, accessSuffix)] = aws.ToTime(obj.LastModified)
+				continue
+			}
+			cache := CacheObject{
+				Key:          *obj.Key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				StorageClass: string(obj.StorageClass),
+				Compression:  compressionFromKey(*obj.Key),
+			}
+			if cache.Compression == "" {
+				continue
+			}
+			caches = append(caches, cache)
 		}
-		return files[i].LastModified.After(*files[j].LastModified)
-	})
+	}
+	for i := range caches {
+		caches[i].LastAccessed = accessed[caches[i].Key]
+	}
+	return caches, nil
+}
 
-	if files[0].Key == nil {
-		return "", errors.New("latest file has nil key")
+// OpenObject returns a streaming reader over an object's body.
+// The caller MUST close the returned reader.
+func OpenObject(key string, bucket string) (io.ReadCloser, error) {
+	session, err := getS3Client(context.TODO())
+	if err != nil {
+		return nil, err
 	}
-	return *files[0].Key, nil
+
+	output, err := session.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
 }
 
 // PutObject uploads an object to S3 with optimized multipart upload.
@@ -242,7 +342,8 @@ func PutObject(key string, bucket string, s3Class string, tc TransferConfig) err
 
 // StreamUpload uploads data from an io.Reader directly to S3 without creating a temp file.
 // This is useful for streaming compressed data directly to S3.
-func StreamUpload(ctx context.Context, reader io.Reader, key string, bucket string, s3Class string, tc TransferConfig) error {
+// metadata is stored as user-defined object metadata and may be nil.
+func StreamUpload(ctx context.Context, reader io.Reader, key string, bucket string, s3Class string, tc TransferConfig, metadata map[string]string) error {
 	session, err := getS3Client(ctx)
 	if err != nil {
 		return err
@@ -256,6 +357,9 @@ func StreamUpload(ctx context.Context, reader io.Reader, key string, bucket stri
 		u.Concurrency = concurrency
 	})
 
+	// The compressed size is unknown until the stream ends, so upload progress has no ETA
+	reader = &progressReader{r: reader, p: newProgress("upload", key, 0)}
+
 	start := time.Now()
 	slog.Info("streaming upload to S3",
 		"key", key,
@@ -269,6 +373,7 @@ func StreamUpload(ctx context.Context, reader io.Reader, key string, bucket stri
 		Key:          aws.String(key),
 		Body:         reader,
 		StorageClass: types.StorageClass(s3Class),
+		Metadata:     metadata,
 	})
 	if err != nil {
 		return err
@@ -284,16 +389,121 @@ func StreamUpload(ctx context.Context, reader io.Reader, key string, bucket stri
 	return nil
 }
 
-// GetObject downloads an object from S3 with optimized multipart download.
+// PutManifest uploads the manifest as a small JSON sidecar object next to the cache archive,
+// so that tooling can inspect a cache without downloading it.
+// The sidecar is encrypted with the primary key when a keyring is given.
+func PutManifest(key string, bucket string, manifest *Manifest, kr *Keyring) error {
+	session, err := getS3Client(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	contentType := "application/json"
+	if kr != nil {
+		var buf bytes.Buffer
+		ew, err := newEncryptWriter(&buf, kr.Primary())
+		if err != nil {
+			return err
+		}
+		if _, err := ew.Write(data); err != nil {
+			return err
+		}
+		if err := ew.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+		contentType = "application/octet-stream"
+	}
+
+	_, err = session.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(manifestKey(key)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+		Metadata:    kr.encryptionMetadata(),
+	})
+	if err == nil {
+		slog.Debug("manifest uploaded", "key", manifestKey(key), "size", getReadableBytes(int64(len(data))))
+	}
+	return err
+}
+
+// GetManifest fetches the sidecar manifest for the given cache key, decrypting it if needed.
+func GetManifest(key string, bucket string, kr *Keyring) (*Manifest, error) {
+	body, err := OpenObject(manifestKey(key), bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	br := bufio.NewReader(body)
+	if isEncrypted(br) {
+		dr, err := newDecryptReader(br, kr)
+		if err != nil {
+			return nil, err
+		}
+		return readManifest(dr)
+	}
+	return readManifest(br)
+}
+
+// PutSignature uploads a cache signature as a sidecar object next to the cache archive.
+func PutSignature(key string, bucket string, sig Signature) error {
+	session, err := getS3Client(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(sig)
+	if err != nil {
+		return fmt.Errorf("failed to encode signature: %w", err)
+	}
+
+	_, err = session.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(signatureKey(key)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err == nil {
+		slog.Debug("signature uploaded", "key", signatureKey(key), "key_id", sig.KeyID)
+	}
+	return err
+}
+
+// GetSignature fetches the signature sidecar for the given cache key.
+func GetSignature(key string, bucket string) (*Signature, error) {
+	body, err := OpenObject(signatureKey(key), bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return readSignature(body)
+}
+
+// GetObject downloads an object from S3 with optimized multipart download
+// into a local file named after the key.
 // Transfer concurrency and part size are controlled via tc.
 func GetObject(key string, bucket string, tc TransferConfig) error {
+	return DownloadObject(context.TODO(), key, bucket, key, 0, tc)
+}
+
+// DownloadObject downloads an object from S3 with optimized multipart download into dest.
+// Transfer concurrency and part size are controlled via tc.
+// size is the object size if already known, used to report progress; 0 if unknown.
+func DownloadObject(ctx context.Context, key string, bucket string, dest string, size int64, tc TransferConfig) error {
 	start := time.Now()
-	session, err := getS3Client(context.TODO())
+	session, err := getS3Client(ctx)
 	if err != nil {
 		return err
 	}
 
-	outFile, err := os.Create(key)
+	outFile, err := os.Create(dest)
 	if err != nil {
 		return err
 	}
@@ -313,7 +523,8 @@ func GetObject(key string, bucket string, tc TransferConfig) error {
 		"concurrency", concurrency,
 	)
 
-	bytesDownloaded, err := downloader.Download(context.TODO(), outFile, &s3.GetObjectInput{
+	out := &progressWriterAt{w: outFile, p: newProgress("download", key, size)}
+	bytesDownloaded, err := downloader.Download(ctx, out, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -359,11 +570,58 @@ func DeleteObject(key string, bucket string) error {
 			size = *objProps.ContentLength
 		}
 		slog.Info("cache deleted successfully", "key", key, "size", getReadableBytes(size))
+
+		// Sidecars may not exist (older caches, never restored); deleting a missing key is a no-op
+		for _, sidecar := range sidecarKeys(key) {
+			if _, err := session.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(sidecar),
+			}); err != nil {
+				slog.Warn("failed to delete cache sidecar", "key", sidecar, "error", err)
+			}
+		}
 	}
 
 	return err
 }
 
+// sidecarKeys returns the keys of every auxiliary object stored alongside a cache archive.
+func sidecarKeys(key string) []string {
+	return []string{manifestKey(key), accessKey(key), signatureKey(key)}
+}
+
+// DeleteObjects removes the given keys using batched DeleteObjects requests.
+// Keys that do not exist are ignored by S3.
+func DeleteObjects(keys []string, bucket string) error {
+	session, err := getS3Client(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		batch := keys[start:min(start+maxDeleteBatch, len(keys))]
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		output, err := session.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			e := output.Errors[0]
+			return fmt.Errorf("failed to delete %d objects, first error on %s: %s",
+				len(output.Errors), aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		slog.Debug("deleted object batch", "count", len(batch))
+	}
+	return nil
+}
+
 // ObjectProperties - Get object properties in s3
 func ObjectProperties(key string, bucket string) (*s3.HeadObjectOutput, error) {
 	session, err := getS3Client(context.TODO())
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -118,7 +120,7 @@ func TestStreamUpload(t *testing.T) {
 	reader, errChan := ZipStream([]string{testDataDir}, CompressionZstd, 0)
 	ctx := context.Background()
 
-	if err := StreamUpload(ctx, reader, testKey, testBucket, "STANDARD", TransferConfig{}); err != nil {
+	if err := StreamUpload(ctx, reader, testKey, testBucket, "STANDARD", TransferConfig{}, nil); err != nil {
 		t.Fatalf("StreamUpload failed: %v", err)
 	}
 
@@ -223,7 +225,7 @@ func TestStreamUploadNoCompression(t *testing.T) {
 	reader, errChan := ZipStream([]string{testDataDir}, CompressionNone, 0)
 	ctx := context.Background()
 
-	if err := StreamUpload(ctx, reader, testKey, testBucket, "STANDARD", TransferConfig{}); err != nil {
+	if err := StreamUpload(ctx, reader, testKey, testBucket, "STANDARD", TransferConfig{}, nil); err != nil {
 		t.Fatalf("StreamUpload (no compression) failed: %v", err)
 	}
 
@@ -436,3 +438,140 @@ func TestDeleteObjectProperties(t *testing.T) {
 		t.Fatal("ObjectProperties should fail or return nil for deleted object")
 	}
 }
+
+func TestListCaches(t *testing.T) {
+	skipIfNoMinIO(t)
+
+	tempDir, err := os.MkdirTemp("", "s3_list_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testDataDir := tempDir + "/data"
+	os.MkdirAll(testDataDir, 0755)
+	os.WriteFile(testDataDir+"/test.txt", []byte("Test content for listing"), 0644)
+
+	origDir, _ := os.Getwd()
+	os.Chdir(tempDir)
+	defer os.Chdir(origDir)
+
+	testKey := "test-list-prefix-1.tar.zst"
+	if err := Zip(testKey, []string{"data"}, CompressionZstd, 0); err != nil {
+		t.Fatalf("failed to create test archive: %v", err)
+	}
+	if err := PutObject(testKey, testBucket, "STANDARD", TransferConfig{}); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	defer DeleteObject(testKey, testBucket)
+	if err := PutManifest(testKey, testBucket, &Manifest{Compression: CompressionZstd}, nil); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	caches, err := ListCaches("test-list-prefix-", testBucket)
+	if err != nil {
+		t.Fatalf("ListCaches failed: %v", err)
+	}
+	if len(caches) != 1 {
+		t.Fatalf("expected 1 cache (manifest sidecar excluded), got %d: %+v", len(caches), caches)
+	}
+	if caches[0].Key != testKey || caches[0].Compression != CompressionZstd || caches[0].Size == 0 {
+		t.Errorf("unexpected cache entry: %+v", caches[0])
+	}
+}
+
+func TestListCachesScoped(t *testing.T) {
+	skipIfNoMinIO(t)
+
+	keys := []string{
+		"test-scoped/linux-yarn-1.tar.zst",
+		"test-scoped/heads/main/linux-yarn-2.tar.zst",
+		"test-scoped/heads/feature/x/linux-yarn-3.tar.zst",
+		"test-scoped/heads/main/linux-gradle-4.tar.zst",
+	}
+	for _, key := range keys {
+		if err := StreamUpload(context.Background(), strings.NewReader("not a real archive"), key, testBucket, "STANDARD", TransferConfig{}, nil); err != nil {
+			t.Fatalf("upload %s failed: %v", key, err)
+		}
+		defer DeleteObject(key, testBucket)
+	}
+
+	scope, _ := ParseScope(ScopeBranch, "refs/heads/main", "", "")
+	action := Action{Bucket: testBucket, KeyPrefix: "test-scoped/", Prefix: "test-scoped/linux-yarn-", Scope: scope}
+	caches, err := listScopedCaches(action)
+	if err != nil {
+		t.Fatalf("listScopedCaches failed: %v", err)
+	}
+	var found []string
+	for _, c := range caches {
+		found = append(found, c.Key)
+	}
+	sort.Strings(found)
+	want := []string{keys[2], keys[1], keys[0]}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("listed %v, want the prefix in every namespace %v", found, want)
+	}
+}
+
+func TestDryRunMakesNoChanges(t *testing.T) {
+	skipIfNoMinIO(t)
+
+	tempDir := t.TempDir()
+	testDataDir := tempDir + "/data"
+	os.MkdirAll(testDataDir, 0755)
+	os.WriteFile(testDataDir+"/test.txt", []byte("Dry run content"), 0644)
+
+	testKey := "test-dry-run.tar.zst"
+	action := Action{
+		Action:      PutAction,
+		Bucket:      testBucket,
+		Key:         testKey,
+		Artifacts:   []string{testDataDir},
+		Compression: CompressionZstd,
+		DryRun:      true,
+	}
+
+	// A dry-run put reports the files without uploading them
+	report := CacheReport{}
+	if err := saveCache(context.Background(), action, TransferConfig{}, &report); err != nil {
+		t.Fatalf("dry-run put failed: %v", err)
+	}
+	if report.Outcome != OutcomeDryRun || report.FileCount != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if exists, _ := ObjectExists(testKey, testBucket); exists {
+		t.Fatal("dry-run put must not upload")
+	}
+
+	// A dry-run delete leaves an existing cache in place
+	reader, errChan := ZipStream([]string{testDataDir}, CompressionZstd, 0)
+	if err := StreamUpload(context.Background(), reader, testKey, testBucket, "STANDARD", TransferConfig{}, nil); err != nil {
+		t.Fatalf("StreamUpload failed: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("compression error: %v", err)
+	}
+	defer DeleteObject(testKey, testBucket)
+
+	// A dry-run get reports the match without restoring it, so it is not a hit
+	action.Action = GetAction
+	os.RemoveAll(testDataDir)
+	result, err := restoreCache(context.Background(), action, TransferConfig{}, &CacheReport{})
+	if err != nil {
+		t.Fatalf("dry-run get failed: %v", err)
+	}
+	if result.Hit || result.MatchedKey != testKey {
+		t.Errorf("expected a matched key without a hit, got %+v", result)
+	}
+	if _, err := os.Stat(testDataDir); !os.IsNotExist(err) {
+		t.Error("dry-run get must not restore files")
+	}
+
+	action.Action = DeleteAction
+	if err := runDelete(action); err != nil {
+		t.Fatalf("dry-run delete failed: %v", err)
+	}
+	if exists, _ := ObjectExists(testKey, testBucket); !exists {
+		t.Fatal("dry-run delete must not delete")
+	}
+}
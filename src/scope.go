@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Cache scopes
+	ScopeGlobal = "global"
+	ScopeBranch = "branch"
+
+	// defaultBranchName is used when the repository's default branch is not provided
+	defaultBranchName = "main"
+)
+
+// Scope - Namespacing of cache keys by git ref.
+// With ScopeBranch, put writes under the current ref and get searches the current ref,
+// then the pull request base ref, then the default branch, mirroring actions/cache.
+type Scope struct {
+	Mode          string // ScopeGlobal or ScopeBranch
+	Ref           string // GITHUB_REF, e.g. refs/heads/feature or refs/pull/1/merge
+	BaseRef       string // GITHUB_BASE_REF, branch name of the pull request base
+	DefaultBranch string // repository default branch name
+}
+
+// ParseScope validates the scope mode and fills in the refs it needs.
+func ParseScope(mode, ref, baseRef, defaultBranch string) (Scope, error) {
+	if mode == "" {
+		mode = ScopeGlobal
+	}
+	if defaultBranch == "" {
+		defaultBranch = defaultBranchName
+	}
+	scope := Scope{Mode: mode, Ref: ref, BaseRef: baseRef, DefaultBranch: defaultBranch}
+
+	switch mode {
+	case ScopeGlobal:
+	case ScopeBranch:
+		if ref == "" {
+			return scope, fmt.Errorf("scope %q requires GITHUB_REF to be set", ScopeBranch)
+		}
+	default:
+		return scope, fmt.Errorf("invalid scope %q, valid options: %s, %s", mode, ScopeGlobal, ScopeBranch)
+	}
+	return scope, nil
+}
+
+// refNamespace turns a git ref into a key namespace: "refs/heads/main" becomes "heads/main".
+// Plain branch names are treated as refs/heads/<name>.
+func refNamespace(ref string) string {
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	return strings.TrimPrefix(ref, "refs/")
+}
+
+// writeNamespace returns the namespace put and delete operate in.
+func (s Scope) writeNamespace() string {
+	if s.Mode != ScopeBranch {
+		return ""
+	}
+	return refNamespace(s.Ref)
+}
+
+// readNamespaces returns the namespaces get searches, most specific first.
+func (s Scope) readNamespaces() []string {
+	if s.Mode != ScopeBranch {
+		return []string{""}
+	}
+
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, ref := range []string{s.Ref, s.BaseRef, s.DefaultBranch} {
+		if ref == "" {
+			continue
+		}
+		ns := refNamespace(ref)
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// namespacedKey places a key inside a namespace. The empty namespace leaves the key unchanged.
+func namespacedKey(namespace string, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + "/" + key
+}
+
+// isRefNamespace reports whether ns is a namespace refNamespace produces for a branch,
+// tag or pull request ref.
+func isRefNamespace(ns string) bool {
+	kind, name, ok := strings.Cut(ns, "/")
+	return ok && name != "" && (kind == "heads" || kind == "tags" || kind == "pull")
+}
+
+// matchesScopedPrefix reports whether key, relative to the key prefix, starts with prefix
+// either directly or inside a ref namespace. Branch names may contain "/", so the key's
+// namespace is taken to be everything before its last strings.Count(prefix, "/")+1 path
+// segments: a prefix must include the directories of the keys it covers.
+func matchesScopedPrefix(key string, prefix string) bool {
+	if strings.HasPrefix(key, prefix) {
+		return true
+	}
+	parts := strings.Split(key, "/")
+	n := strings.Count(prefix, "/") + 1
+	if len(parts) <= n {
+		return false
+	}
+	split := len(parts) - n
+	return isRefNamespace(strings.Join(parts[:split], "/")) && strings.HasPrefix(strings.Join(parts[split:], "/"), prefix)
+}
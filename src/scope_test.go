@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	scope, err := ParseScope("", "", "", "")
+	if err != nil {
+		t.Fatalf("ParseScope failed: %v", err)
+	}
+	if scope.Mode != ScopeGlobal || scope.DefaultBranch != defaultBranchName {
+		t.Errorf("unexpected default scope: %+v", scope)
+	}
+
+	if _, err := ParseScope(ScopeBranch, "", "", ""); err == nil {
+		t.Error("expected error for branch scope without a ref")
+	}
+	if _, err := ParseScope("repo", "refs/heads/main", "", ""); err == nil {
+		t.Error("expected error for invalid scope")
+	}
+}
+
+func TestRefNamespace(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"refs/heads/main", "heads/main"},
+		{"refs/heads/feature/x", "heads/feature/x"},
+		{"refs/pull/42/merge", "pull/42/merge"},
+		{"refs/tags/v1.0.0", "tags/v1.0.0"},
+		{"main", "heads/main"},
+	}
+	for _, tt := range tests {
+		if got := refNamespace(tt.ref); got != tt.expected {
+			t.Errorf("refNamespace(%q) = %q, want %q", tt.ref, got, tt.expected)
+		}
+	}
+}
+
+func TestScopeNamespaces(t *testing.T) {
+	global := Scope{Mode: ScopeGlobal, Ref: "refs/heads/feature"}
+	if ns := global.writeNamespace(); ns != "" {
+		t.Errorf("global writeNamespace = %q, want empty", ns)
+	}
+	if ns := global.readNamespaces(); !reflect.DeepEqual(ns, []string{""}) {
+		t.Errorf("global readNamespaces = %v, want [\"\"]", ns)
+	}
+
+	pr := Scope{Mode: ScopeBranch, Ref: "refs/pull/42/merge", BaseRef: "develop", DefaultBranch: "main"}
+	if ns := pr.writeNamespace(); ns != "pull/42/merge" {
+		t.Errorf("writeNamespace = %q, want pull/42/merge", ns)
+	}
+	want := []string{"pull/42/merge", "heads/develop", "heads/main"}
+	if ns := pr.readNamespaces(); !reflect.DeepEqual(ns, want) {
+		t.Errorf("readNamespaces = %v, want %v", ns, want)
+	}
+
+	// Pushes to the default branch search a single namespace
+	push := Scope{Mode: ScopeBranch, Ref: "refs/heads/main", DefaultBranch: "main"}
+	if ns := push.readNamespaces(); !reflect.DeepEqual(ns, []string{"heads/main"}) {
+		t.Errorf("readNamespaces = %v, want [heads/main]", ns)
+	}
+}
+
+func TestNamespacedKey(t *testing.T) {
+	if got := namespacedKey("", "linux-yarn.tar.zst"); got != "linux-yarn.tar.zst" {
+		t.Errorf("namespacedKey with empty namespace = %q", got)
+	}
+	if got := namespacedKey("heads/main", "linux-yarn.tar.zst"); got != "heads/main/linux-yarn.tar.zst" {
+		t.Errorf("namespacedKey = %q", got)
+	}
+}
+
+func TestMatchesScopedPrefix(t *testing.T) {
+	tests := []struct {
+		key      string
+		prefix   string
+		expected bool
+	}{
+		{"linux-yarn-abc.tar.zst", "linux-yarn-", true},
+		{"heads/main/linux-yarn-abc.tar.zst", "linux-yarn-", true},
+		{"heads/feature/x/linux-yarn-abc.tar.zst", "linux-yarn-", true},
+		{"pull/42/merge/linux-yarn-abc.tar.zst", "linux-yarn-", true},
+		{"tags/v1/linux-yarn-abc.tar.zst", "linux-yarn-", true},
+		{"heads/main/linux-gradle-abc.tar.zst", "linux-yarn-", false},
+		// A branch named after the prefix does not pull in its other caches
+		{"heads/linux-yarn-fix/other.tar.zst", "linux-yarn-", false},
+		{"heads/main/tools/linux-abc.tar.zst", "tools/linux-", true},
+		{"heads/main/linux-yarn-abc.tar.zst", "heads/main/", true},
+		{"heads/feature/linux-yarn-abc.tar.zst", "heads/main/", false},
+		{"other/main/linux-yarn-abc.tar.zst", "linux-yarn-", false},
+	}
+	for _, tt := range tests {
+		if got := matchesScopedPrefix(tt.key, tt.prefix); got != tt.expected {
+			t.Errorf("matchesScopedPrefix(%q, %q) = %v, want %v", tt.key, tt.prefix, got, tt.expected)
+		}
+	}
+}
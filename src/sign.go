@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// signatureSuffix is appended to a cache key to form the sidecar signature object key.
+	signatureSuffix = ".sig"
+
+	// SignatureEd25519 - Signature algorithm recorded in signature sidecars
+	SignatureEd25519 = "ed25519"
+
+	// signatureContext domain-separates cache signatures from any other use of the key
+	signatureContext = "s3cache-signature-v1"
+)
+
+type (
+	// Signer - An ed25519 private key used to sign cache archives
+	Signer struct {
+		ID  string
+		key ed25519.PrivateKey
+	}
+
+	// TrustedKeys - Public keys whose signatures are accepted on restore
+	TrustedKeys struct {
+		keys map[string]ed25519.PublicKey
+	}
+
+	// Signature - Sidecar object proving who produced a cache archive.
+	// The signed message binds the archive digest to the object key, so a signed
+	// archive cannot be replayed under a different key.
+	Signature struct {
+		Algorithm string `json:"algorithm"`
+		KeyID     string `json:"key_id"`
+		Digest    string `json:"digest"`
+		Signature string `json:"signature"`
+	}
+)
+
+// ErrUntrustedCache is returned when a cache is unsigned or its signature does not verify.
+var ErrUntrustedCache = errors.New("cache signature is missing or invalid")
+
+// signatureKey returns the sidecar object key holding the signature for the given cache key.
+func signatureKey(key string) string {
+	return key + signatureSuffix
+}
+
+// keyFingerprint identifies a public key by a short hash of its bytes.
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// readKeyLines returns the non-empty, non-comment lines of an inline value and an optional file.
+func readKeyLines(inline string, path string) ([]string, error) {
+	lines := strings.Split(inline, "\n")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+
+	var out []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			out = append(out, line)
+		}
+	}
+	return out, nil
+}
+
+// ParseSigner decodes an ed25519 private key given as base64 of the 32-byte seed or
+// the 64-byte private key, read from inline or, if empty, from path.
+// Returns nil if neither is set.
+func ParseSigner(inline string, path string) (*Signer, error) {
+	lines, err := readKeyLines(inline, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key file: %w", err)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	var key ed25519.PrivateKey
+	switch len(raw) {
+	case ed25519.SeedSize:
+		key = ed25519.NewKeyFromSeed(raw)
+	case ed25519.PrivateKeySize:
+		key = ed25519.PrivateKey(raw)
+	default:
+		return nil, fmt.Errorf("invalid signing key: must be a %d byte seed or %d byte private key encoded as base64",
+			ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+
+	return &Signer{ID: keyFingerprint(key.Public().(ed25519.PublicKey)), key: key}, nil
+}
+
+// ParseTrustedKeys decodes base64 ed25519 public keys, one per line, from inline and path.
+// Returns nil if no keys are configured, meaning signatures are not checked.
+func ParseTrustedKeys(inline string, path string) (*TrustedKeys, error) {
+	lines, err := readKeyLines(inline, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted keys file: %w", err)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	tk := &TrustedKeys{keys: make(map[string]ed25519.PublicKey)}
+	for _, line := range lines {
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid trusted key %q: must be a %d byte ed25519 public key encoded as base64",
+				line, ed25519.PublicKeySize)
+		}
+		pub := ed25519.PublicKey(raw)
+		tk.keys[keyFingerprint(pub)] = pub
+	}
+	return tk, nil
+}
+
+// signedMessage returns the bytes covered by a cache signature.
+func signedMessage(key string, digest string) []byte {
+	return []byte(signatureContext + "\n" + key + "\n" + digest)
+}
+
+// Sign produces a signature over the archive digest for the given object key.
+func (s *Signer) Sign(key string, digest string) Signature {
+	return Signature{
+		Algorithm: SignatureEd25519,
+		KeyID:     s.ID,
+		Digest:    digest,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedMessage(key, digest))),
+	}
+}
+
+// Verify checks that sig is a valid signature by a trusted key over digest for the object key.
+func (tk *TrustedKeys) Verify(key string, digest string, sig *Signature) error {
+	if err := tk.VerifySigner(key, sig); err != nil {
+		return err
+	}
+	if sig.Digest != digest {
+		return fmt.Errorf("%w: digest mismatch", ErrUntrustedCache)
+	}
+	return nil
+}
+
+// VerifySigner checks that sig is a valid signature by a trusted key for the object key,
+// over whatever digest it records. It is all that can be checked without the archive.
+func (tk *TrustedKeys) VerifySigner(key string, sig *Signature) error {
+	if sig == nil {
+		return fmt.Errorf("%w: no signature", ErrUntrustedCache)
+	}
+	if sig.Algorithm != SignatureEd25519 {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrUntrustedCache, sig.Algorithm)
+	}
+	pub, ok := tk.keys[sig.KeyID]
+	if !ok {
+		return fmt.Errorf("%w: signed by untrusted key %q", ErrUntrustedCache, sig.KeyID)
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(pub, signedMessage(key, sig.Digest), raw) {
+		return fmt.Errorf("%w: bad signature", ErrUntrustedCache)
+	}
+	return nil
+}
+
+// formatDigest renders a SHA-256 sum in the "sha256:<hex>" form stored in signatures.
+func formatDigest(sum []byte) string {
+	return "sha256:" + hex.EncodeToString(sum)
+}
+
+// fileDigest returns the digest of a downloaded archive.
+func fileDigest(path string) (string, error) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + sum, nil
+}
+
+// readSignature decodes a signature sidecar.
+func readSignature(r io.Reader) (*Signature, error) {
+	var sig Signature
+	if err := json.NewDecoder(r).Decode(&sig); err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	return &sig, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSigner(t *testing.T) (*Signer, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ParseSigner(base64.StdEncoding.EncodeToString(priv.Seed()), "")
+	if err != nil {
+		t.Fatalf("ParseSigner failed: %v", err)
+	}
+	return signer, base64.StdEncoding.EncodeToString(pub)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	_, otherPub := newTestSigner(t)
+
+	trusted, err := ParseTrustedKeys(otherPub+"\n"+pub, "")
+	if err != nil {
+		t.Fatalf("ParseTrustedKeys failed: %v", err)
+	}
+
+	key := "linux-yarn-abc.tar.zst"
+	digest := "sha256:0123"
+	sig := signer.Sign(key, digest)
+
+	if err := trusted.Verify(key, digest, &sig); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		digest string
+		sig    *Signature
+	}{
+		{"unsigned", key, digest, nil},
+		{"digest_mismatch", key, "sha256:4567", &sig},
+		{"replayed_under_other_key", "linux-yarn-evil.tar.zst", digest, &sig},
+		{"forged", key, digest, &Signature{Algorithm: SignatureEd25519, KeyID: sig.KeyID, Digest: digest,
+			Signature: base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := trusted.Verify(tt.key, tt.digest, tt.sig); !errors.Is(err, ErrUntrustedCache) {
+				t.Errorf("expected ErrUntrustedCache, got %v", err)
+			}
+		})
+	}
+
+	untrusted, _ := ParseTrustedKeys(otherPub, "")
+	if err := untrusted.Verify(key, digest, &sig); !errors.Is(err, ErrUntrustedCache) {
+		t.Errorf("signature by untrusted key accepted: %v", err)
+	}
+
+	// Without the archive only the signer and the object key can be checked
+	if err := trusted.VerifySigner(key, &sig); err != nil {
+		t.Errorf("valid signature rejected without digest: %v", err)
+	}
+	if err := trusted.VerifySigner(key, nil); !errors.Is(err, ErrUntrustedCache) {
+		t.Errorf("missing signature accepted without digest: %v", err)
+	}
+	if err := trusted.VerifySigner("linux-yarn-evil.tar.zst", &sig); !errors.Is(err, ErrUntrustedCache) {
+		t.Errorf("replayed signature accepted without digest: %v", err)
+	}
+	if err := untrusted.VerifySigner(key, &sig); !errors.Is(err, ErrUntrustedCache) {
+		t.Errorf("signature by untrusted key accepted without digest: %v", err)
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	if s, err := ParseSigner("", ""); s != nil || err != nil {
+		t.Errorf("expected nil signer when unset, got %v, %v", s, err)
+	}
+	if _, err := ParseSigner("c2hvcnQ=", ""); err == nil {
+		t.Error("expected error for short signing key")
+	}
+	if _, err := ParseTrustedKeys("not-base64!", ""); err == nil {
+		t.Error("expected error for invalid trusted key")
+	}
+
+	_, pub := newTestSigner(t)
+	path := filepath.Join(t.TempDir(), "trusted")
+	os.WriteFile(path, []byte("# ci signer\n"+pub+"\n"), 0644)
+	tk, err := ParseTrustedKeys("", path)
+	if err != nil {
+		t.Fatalf("ParseTrustedKeys from file failed: %v", err)
+	}
+	if len(tk.keys) != 1 {
+		t.Errorf("expected 1 trusted key, got %d", len(tk.keys))
+	}
+}
+
+func TestFileDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive")
+	os.WriteFile(path, []byte("hello"), 0644)
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		t.Fatalf("fileDigest failed: %v", err)
+	}
+	want := "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if digest != want {
+		t.Errorf("fileDigest = %q, want %q", digest, want)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	// Server-side encryption modes
+	SSEAES256            = "AES256"
+	SSEKMS               = "aws:kms"
+	SSEKMSDSSE           = "aws:kms:dsse"
+	sseCustomerAlgorithm = "AES256"
+)
+
+// ServerSideEncryption holds S3 server-side encryption settings.
+// Zero values mean "use the bucket default".
+type ServerSideEncryption struct {
+	Mode             string // AES256, aws:kms or aws:kms:dsse
+	KMSKeyID         string // KMS key for aws:kms and aws:kms:dsse
+	BucketKeyEnabled bool   // use an S3 bucket key for SSE-KMS
+	CustomerKey      []byte // 32 byte SSE-C key, mutually exclusive with Mode
+}
+
+// serverSideEncryption is applied to every S3 request made by getS3Client.
+var serverSideEncryption ServerSideEncryption
+
+// SetServerSideEncryption sets the server-side encryption applied to all S3 requests.
+func SetServerSideEncryption(sse ServerSideEncryption) {
+	serverSideEncryption = sse
+	resetS3Client()
+}
+
+// ParseServerSideEncryption validates the server-side encryption inputs.
+// customerKey is the base64-encoded SSE-C key.
+func ParseServerSideEncryption(mode, kmsKeyID string, bucketKey bool, customerKey string) (ServerSideEncryption, error) {
+	sse := ServerSideEncryption{Mode: mode, KMSKeyID: kmsKeyID, BucketKeyEnabled: bucketKey}
+
+	switch mode {
+	case "", SSEAES256, SSEKMS, SSEKMSDSSE:
+	default:
+		return sse, fmt.Errorf("invalid sse mode %q, valid options: %s, %s, %s", mode, SSEAES256, SSEKMS, SSEKMSDSSE)
+	}
+	if kmsKeyID != "" && mode != SSEKMS && mode != SSEKMSDSSE {
+		return sse, fmt.Errorf("sse-kms-key-id requires sse to be %s or %s", SSEKMS, SSEKMSDSSE)
+	}
+	// S3 only uses bucket keys for SSE-KMS; DSSE-KMS does not support them
+	if bucketKey && mode != SSEKMS {
+		return sse, fmt.Errorf("sse-bucket-key requires sse to be %s", SSEKMS)
+	}
+
+	if customerKey != "" {
+		if mode != "" {
+			return sse, fmt.Errorf("sse-customer-key cannot be combined with sse %q", mode)
+		}
+		key, err := base64.StdEncoding.DecodeString(customerKey)
+		if err != nil || len(key) != 32 {
+			return sse, fmt.Errorf("invalid sse-customer-key: must be 32 bytes encoded as base64")
+		}
+		sse.CustomerKey = key
+	}
+	return sse, nil
+}
+
+// describe returns a loggable summary of the settings without key material.
+func (sse ServerSideEncryption) describe() string {
+	switch {
+	case len(sse.CustomerKey) > 0:
+		return "SSE-C"
+	case sse.Mode == "":
+		return "bucket default"
+	default:
+		return sse.Mode
+	}
+}
+
+func (sse ServerSideEncryption) enabled() bool {
+	return sse.Mode != "" || len(sse.CustomerKey) > 0
+}
+
+// customerKeyHeaders returns the SSE-C algorithm, key and key MD5 request values.
+func (sse ServerSideEncryption) customerKeyHeaders() (*string, *string, *string) {
+	sum := md5.Sum(sse.CustomerKey)
+	return aws.String(sseCustomerAlgorithm),
+		aws.String(base64.StdEncoding.EncodeToString(sse.CustomerKey)),
+		aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// apply sets encryption parameters on any S3 operation input that accepts them.
+// Writes carry the SSE mode and KMS key; with SSE-C every read and write of the
+// object, including multipart parts and copy sources, must carry the customer key.
+func (sse ServerSideEncryption) apply(params interface{}) {
+	mode := types.ServerSideEncryption(sse.Mode)
+	var kmsKeyID *string
+	if sse.KMSKeyID != "" {
+		kmsKeyID = aws.String(sse.KMSKeyID)
+	}
+	var bucketKey *bool
+	if sse.BucketKeyEnabled {
+		bucketKey = aws.Bool(true)
+	}
+	sseC := len(sse.CustomerKey) > 0
+
+	switch in := params.(type) {
+	case *s3.PutObjectInput:
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = mode, kmsKeyID, bucketKey
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.CreateMultipartUploadInput:
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = mode, kmsKeyID, bucketKey
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.CopyObjectInput:
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = mode, kmsKeyID, bucketKey
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+			in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.UploadPartInput:
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.UploadPartCopyInput:
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+			in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.CompleteMultipartUploadInput:
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.GetObjectInput:
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	case *s3.HeadObjectInput:
+		if sseC {
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = sse.customerKeyHeaders()
+		}
+	}
+}
+
+// apiOption registers a middleware that applies the encryption settings to every request.
+func (sse ServerSideEncryption) apiOption(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3CacheServerSideEncryption",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+			middleware.InitializeOutput, middleware.Metadata, error,
+		) {
+			sse.apply(in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseServerSideEncryption(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	tests := []struct {
+		name        string
+		mode        string
+		kmsKeyID    string
+		bucketKey   bool
+		customerKey string
+		expectErr   bool
+	}{
+		{"unset", "", "", false, "", false},
+		{"aes256", SSEAES256, "", false, "", false},
+		{"kms_with_key", SSEKMS, "arn:aws:kms:us-east-1:123:key/abc", false, "", false},
+		{"dsse", SSEKMSDSSE, "", false, "", false},
+		{"invalid_mode", "aws:magic", "", false, "", true},
+		{"kms_key_without_kms_mode", SSEAES256, "abc", false, "", true},
+		{"sse_c", "", "", false, customerKey, false},
+		{"sse_c_with_mode", SSEKMS, "", false, customerKey, true},
+		{"kms_bucket_key", SSEKMS, "", true, "", false},
+		{"bucket_key_without_kms", SSEAES256, "", true, "", true},
+		{"bucket_key_with_dsse", SSEKMSDSSE, "", true, "", true},
+		{"bucket_key_without_mode", "", "", true, "", true},
+		{"sse_c_short_key", "", "", false, base64.StdEncoding.EncodeToString([]byte("short")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseServerSideEncryption(tt.mode, tt.kmsKeyID, tt.bucketKey, tt.customerKey)
+			if tt.expectErr && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestServerSideEncryptionApplyKMS(t *testing.T) {
+	sse := ServerSideEncryption{Mode: SSEKMS, KMSKeyID: "my-key", BucketKeyEnabled: true}
+
+	put := &s3.PutObjectInput{}
+	sse.apply(put)
+	if put.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(put.SSEKMSKeyId) != "my-key" || !aws.ToBool(put.BucketKeyEnabled) {
+		t.Errorf("PutObjectInput not configured for SSE-KMS: %+v", put)
+	}
+
+	create := &s3.CreateMultipartUploadInput{}
+	sse.apply(create)
+	if create.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(create.SSEKMSKeyId) != "my-key" {
+		t.Errorf("CreateMultipartUploadInput not configured for SSE-KMS: %+v", create)
+	}
+
+	copyIn := &s3.CopyObjectInput{}
+	sse.apply(copyIn)
+	if copyIn.ServerSideEncryption != types.ServerSideEncryptionAwsKms {
+		t.Errorf("CopyObjectInput not configured for SSE-KMS: %+v", copyIn)
+	}
+
+	// Reads must not carry SSE-KMS headers
+	get := &s3.GetObjectInput{}
+	sse.apply(get)
+	if get.SSECustomerKey != nil {
+		t.Errorf("GetObjectInput should be untouched for SSE-KMS: %+v", get)
+	}
+}
+
+func TestServerSideEncryptionApplyCustomerKey(t *testing.T) {
+	sse, err := ParseServerSideEncryption("", "", false, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatalf("ParseServerSideEncryption failed: %v", err)
+	}
+
+	inputs := []interface{}{
+		&s3.PutObjectInput{}, &s3.GetObjectInput{}, &s3.HeadObjectInput{},
+		&s3.UploadPartInput{}, &s3.CompleteMultipartUploadInput{}, &s3.CopyObjectInput{},
+	}
+	for _, in := range inputs {
+		sse.apply(in)
+	}
+
+	head := inputs[2].(*s3.HeadObjectInput)
+	if aws.ToString(head.SSECustomerAlgorithm) != "AES256" || head.SSECustomerKey == nil || head.SSECustomerKeyMD5 == nil {
+		t.Errorf("HeadObjectInput missing SSE-C headers: %+v", head)
+	}
+	part := inputs[3].(*s3.UploadPartInput)
+	if part.SSECustomerKey == nil {
+		t.Error("UploadPartInput missing SSE-C key")
+	}
+	copyIn := inputs[5].(*s3.CopyObjectInput)
+	if copyIn.SSECustomerKey == nil || copyIn.CopySourceSSECustomerKey == nil {
+		t.Error("CopyObjectInput missing SSE-C source or destination key")
+	}
+	if sse.describe() != "SSE-C" {
+		t.Errorf("describe() = %q, want SSE-C", sse.describe())
+	}
+}
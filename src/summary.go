@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cache outcomes shown in the job summary
+const (
+	OutcomeExact   = "exact"   // get: restored the primary key
+	OutcomePartial = "partial" // get: restored the latest cache matching a restore key
+	OutcomeMiss    = "miss"    // get: nothing usable was found
+	OutcomeSaved   = "saved"   // put: uploaded a new cache
+	OutcomeExists  = "exists"  // put: the key already existed, nothing was uploaded
+	OutcomeDryRun  = "dry-run" // put: would have uploaded a new cache
+)
+
+type (
+	// PhaseTimings - Wall time spent in each phase of a get or put
+	PhaseTimings struct {
+		List       time.Duration // finding the cache, or checking it does not exist yet
+		Download   time.Duration
+		Decompress time.Duration // including decryption
+		Extract    time.Duration
+		Upload     time.Duration // scanning, archiving and uploading, which overlap
+		Total      time.Duration // the whole get or put
+	}
+
+	// CacheReport - What happened to one cache, rendered into the job summary and metrics
+	CacheReport struct {
+		Name             string // config file entry, empty for a single cache
+		Key              string // primary key
+		Prefix           string // key prefix the cache is grouped under in metrics
+		MatchedKey       string
+		MatchedPrefix    string // restore key a partial hit was found under
+		Outcome          string
+		ArchiveSize      int64
+		UncompressedSize int64
+		FileCount        int
+		Timings          PhaseTimings
+	}
+)
+
+// setMatch records the restored cache and whether it was an exact or partial hit.
+func (r *CacheReport) setMatch(m *cacheMatch) {
+	r.MatchedKey = m.Key
+	r.MatchedPrefix = m.Prefix
+	r.Outcome = OutcomeExact
+	if m.Prefix != "" {
+		r.Outcome = OutcomePartial
+	}
+}
+
+// compressionRatio returns the uncompressed to archive size ratio, or "-" if unknown.
+func (r CacheReport) compressionRatio() string {
+	if r.ArchiveSize <= 0 || r.UncompressedSize <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fx", float64(r.UncompressedSize)/float64(r.ArchiveSize))
+}
+
+// WriteStepSummary appends a Markdown table of the reports to the file named by
+// GITHUB_STEP_SUMMARY. Outside of GitHub Actions, where the variable is unset, it does nothing.
+func WriteStepSummary(action string, reports []CacheReport) {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" || len(reports) == 0 {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Warn("failed to open step summary", "error", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(renderSummary(action, reports)); err != nil {
+		slog.Warn("failed to write step summary", "error", err)
+	}
+}
+
+// renderSummary formats the reports as a Markdown section.
+func renderSummary(action string, reports []CacheReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### S3 cache %s\n\n", action)
+
+	named := reports[0].Name != ""
+	header := []string{"Key", "Matched", "Result", "Archive", "Ratio", "Files",
+		"List", "Download", "Decompress", "Extract", "Upload"}
+	if named {
+		header = append([]string{"Cache"}, header...)
+	}
+	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	b.WriteString("|" + strings.Repeat(" --- |", len(header)) + "\n")
+
+	for _, r := range reports {
+		matched := "-"
+		if r.MatchedPrefix != "" {
+			matched = "`" + r.MatchedPrefix + "`"
+		}
+		row := []string{
+			"`" + r.Key + "`",
+			matched,
+			r.Outcome,
+			formatSize(r.ArchiveSize),
+			r.compressionRatio(),
+			formatCount(r.FileCount),
+			formatPhase(r.Timings.List),
+			formatPhase(r.Timings.Download),
+			formatPhase(r.Timings.Decompress),
+			formatPhase(r.Timings.Extract),
+			formatPhase(r.Timings.Upload),
+		}
+		if named {
+			row = append([]string{r.Name}, row...)
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func formatSize(n int64) string {
+	if n <= 0 {
+		return "-"
+	}
+	return getReadableBytes(n)
+}
+
+func formatCount(n int) string {
+	if n <= 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+func formatPhase(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
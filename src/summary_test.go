@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderSummary(t *testing.T) {
+	reports := []CacheReport{{
+		Key:              "linux-yarn-abc.tar.zst",
+		MatchedKey:       "linux-yarn-old.tar.zst",
+		MatchedPrefix:    "linux-yarn-",
+		Outcome:          OutcomePartial,
+		ArchiveSize:      1000,
+		UncompressedSize: 4000,
+		FileCount:        12,
+		Timings:          PhaseTimings{List: 120 * time.Millisecond, Download: 2 * time.Second},
+	}}
+
+	out := renderSummary(GetAction, reports)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if lines[0] != "### S3 cache get" {
+		t.Errorf("unexpected heading %q", lines[0])
+	}
+	if len(lines) != 5 {
+		t.Fatalf("expected heading, blank line, header, separator and one row, got:\n%s", out)
+	}
+	want := "| `linux-yarn-abc.tar.zst` | `linux-yarn-` | partial | 1.0 kB | 4.0x | 12 | 120ms | 2s | - | - | - |"
+	if lines[4] != want {
+		t.Errorf("row = %q, want %q", lines[4], want)
+	}
+
+	reports[0].Name = "yarn"
+	if out := renderSummary(GetAction, reports); !strings.Contains(out, "| Cache | Key |") || !strings.Contains(out, "| yarn | ") {
+		t.Errorf("named caches should get a Cache column:\n%s", out)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	if got := (CacheReport{ArchiveSize: 250, UncompressedSize: 1000}).compressionRatio(); got != "4.0x" {
+		t.Errorf("compressionRatio = %q, want 4.0x", got)
+	}
+	if got := (CacheReport{}).compressionRatio(); got != "-" {
+		t.Errorf("compressionRatio of empty report = %q, want -", got)
+	}
+}
+
+func TestWriteStepSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", path)
+
+	WriteStepSummary(PutAction, []CacheReport{{Key: "a.tar.zst", Outcome: OutcomeSaved}})
+	WriteStepSummary(PutAction, []CacheReport{{Key: "b.tar.zst", Outcome: OutcomeExists}})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read summary: %v", err)
+	}
+	if strings.Count(string(data), "### S3 cache put") != 2 {
+		t.Errorf("expected summaries to be appended:\n%s", data)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Phase span names. Spans of the same name are totalled in the report's phases.
+const (
+	SpanLookup       = "s3.lookup"           // finding the cache, or checking the key is free
+	SpanWalk         = "archive.walk"        // scanning and hashing the artifacts into a manifest
+	SpanCompress     = "archive.compress"    // archiving, compressing and encrypting, excluding upload waits
+	SpanUpload       = "s3.upload"           // the whole streaming upload
+	SpanUploadPart   = "s3.upload_part"      // one part request, recorded by the S3 client middleware
+	SpanDownload     = "s3.download"         // the whole download
+	SpanDownloadPart = "s3.download_part"    // one ranged GET including its body, recorded by the middleware
+	SpanVerify       = "signature.verify"    // hashing the archive and checking its signature
+	SpanExtract      = "archive.extract"     // the whole extraction
+	SpanDecompress   = "archive.decompress"  // decrypting and decompressing, accumulated over the extraction
+	SpanWriteFiles   = "archive.write_files" // writing and verifying files, accumulated over the extraction
+)
+
+type (
+	// TelemetryConfig - Where phase timings are exported once the action finishes
+	TelemetryConfig struct {
+		ReportFile   string            // JSON report path, empty = no report
+		OTLPEndpoint string            // OTLP/HTTP traces URL, empty = no export
+		OTLPHeaders  map[string]string // extra request headers, e.g. for authentication
+		ServiceName  string
+	}
+
+	// Span - A timed phase of an action. Spans nest through the context passed to StartSpan.
+	Span struct {
+		Name      string
+		ID        string
+		ParentID  string
+		StartTime time.Time
+		EndTime   time.Time
+		Aggregate bool // accumulated over interleaved work rather than one contiguous interval
+		Attrs     map[string]any
+		Error     string
+
+		mu     sync.Mutex
+		ended  bool
+		tracer *Tracer
+	}
+
+	// Tracer - Collects the finished spans of one run
+	Tracer struct {
+		mu      sync.Mutex
+		traceID string
+		spans   []*Span
+	}
+)
+
+// tracer records every span started without a parent span in its context.
+var tracer = newTracer()
+
+func newTracer() *Tracer {
+	return &Tracer{traceID: randomHex(16)}
+}
+
+type spanContextKey struct{}
+
+// spanFromContext returns the span carried by ctx, or nil.
+func spanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
+
+// Start begins a span recorded by t, as a child of the span in ctx if any.
+// attrs are key/value pairs, as for slog.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	s := &Span{Name: name, ID: randomHex(8), StartTime: time.Now(), tracer: t}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.ParentID = parent.ID
+	}
+	s.Set(attrs...)
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// StartSpan begins a span as a child of the span in ctx, recorded by the same tracer.
+// Without a parent the span is a root of the process-wide tracer.
+func StartSpan(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	t := tracer
+	if parent := spanFromContext(ctx); parent != nil {
+		t = parent.tracer
+	}
+	return t.Start(ctx, name, attrs...)
+}
+
+// recordPhase records an already measured phase under the span in ctx. Used for time that is
+// accumulated across interleaved work, such as decompression during extraction.
+func recordPhase(ctx context.Context, name string, start time.Time, elapsed time.Duration, attrs ...any) {
+	_, s := StartSpan(ctx, name, attrs...)
+	s.StartTime = start
+	s.Aggregate = true
+	s.finish(start.Add(elapsed))
+}
+
+// Set adds key/value attributes to the span.
+func (s *Span) Set(attrs ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		if s.Attrs == nil {
+			s.Attrs = make(map[string]any)
+		}
+		s.Attrs[key] = attrs[i+1]
+	}
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span. Only the first call has any effect.
+func (s *Span) End() {
+	s.finish(time.Now())
+}
+
+func (s *Span) finish(end time.Time) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = end
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+func (s *Span) duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// finished returns the ended spans ordered by start time.
+func (t *Tracer) finished() []*Span {
+	t.mu.Lock()
+	spans := slices.Clone(t.spans)
+	t.mu.Unlock()
+	slices.SortStableFunc(spans, func(a, b *Span) int { return a.StartTime.Compare(b.StartTime) })
+	return spans
+}
+
+type (
+	// TraceReport - The machine-readable report written to REPORT_FILE
+	TraceReport struct {
+		TraceID    string                 `json:"trace_id"`
+		Action     string                 `json:"action"`
+		Start      time.Time              `json:"start"`
+		DurationMS float64                `json:"duration_ms"`
+		Phases     map[string]PhaseTotals `json:"phases"`
+		Spans      []SpanRecord           `json:"spans"`
+	}
+
+	// PhaseTotals - All spans of one phase. Parallel parts overlap, so the total may exceed wall time.
+	PhaseTotals struct {
+		Count   int     `json:"count"`
+		TotalMS float64 `json:"total_ms"`
+	}
+
+	// SpanRecord - One span in the report
+	SpanRecord struct {
+		Name       string         `json:"name"`
+		SpanID     string         `json:"span_id"`
+		ParentID   string         `json:"parent_id,omitempty"`
+		Start      time.Time      `json:"start"`
+		DurationMS float64        `json:"duration_ms"`
+		Aggregate  bool           `json:"aggregate,omitempty"`
+		Attributes map[string]any `json:"attributes,omitempty"`
+		Error      string         `json:"error,omitempty"`
+	}
+)
+
+// report summarises the finished spans.
+func (t *Tracer) report(action string) TraceReport {
+	r := TraceReport{TraceID: t.traceID, Action: action, Phases: make(map[string]PhaseTotals), Spans: []SpanRecord{}}
+	var end time.Time
+	for i, s := range t.finished() {
+		if i == 0 {
+			r.Start = s.StartTime
+		}
+		if s.EndTime.After(end) {
+			end = s.EndTime
+		}
+		p := r.Phases[s.Name]
+		p.Count++
+		p.TotalMS += milliseconds(s.duration())
+		r.Phases[s.Name] = p
+		r.Spans = append(r.Spans, SpanRecord{
+			Name:       s.Name,
+			SpanID:     s.ID,
+			ParentID:   s.ParentID,
+			Start:      s.StartTime,
+			DurationMS: milliseconds(s.duration()),
+			Aggregate:  s.Aggregate,
+			Attributes: s.Attrs,
+			Error:      s.Error,
+		})
+	}
+	if !r.Start.IsZero() {
+		r.DurationMS = milliseconds(end.Sub(r.Start))
+	}
+	return r
+}
+
+// WriteReport writes the JSON report to path.
+func (t *Tracer) WriteReport(path string, action string) error {
+	data, err := json.MarshalIndent(t.report(action), "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ExportTelemetry writes the report and sends the trace as configured. Telemetry is best
+// effort: failures are logged and never fail the action.
+func ExportTelemetry(action string, cfg TelemetryConfig) {
+	if cfg.ReportFile != "" {
+		if err := tracer.WriteReport(cfg.ReportFile, action); err != nil {
+			slog.Warn("failed to write timing report", "path", cfg.ReportFile, "error", err)
+		} else {
+			slog.Debug("wrote timing report", "path", cfg.ReportFile)
+		}
+	}
+	if cfg.OTLPEndpoint != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+		defer cancel()
+		if err := tracer.exportOTLP(ctx, cfg); err != nil {
+			slog.Warn("failed to export trace", "endpoint", cfg.OTLPEndpoint, "error", err)
+		} else {
+			slog.Debug("exported trace", "endpoint", cfg.OTLPEndpoint, "trace_id", tracer.traceID)
+		}
+	}
+}
+
+// ParseTelemetry reads the export settings. The OTLP variables follow the OpenTelemetry
+// exporter conventions, so an existing collector setup can be reused as is.
+func ParseTelemetry(p *configProblems) TelemetryConfig {
+	cfg := TelemetryConfig{
+		ReportFile:  strings.TrimSpace(os.Getenv("REPORT_FILE")),
+		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "s3-cache"
+	}
+
+	// A signal-specific endpoint is used as is; the generic one is a base URL
+	endpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	if endpoint == "" {
+		if base := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return cfg
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.errorf("invalid OTLP endpoint %q: must be an http or https URL", endpoint)
+		return cfg
+	}
+	cfg.OTLPEndpoint = endpoint
+
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/json" {
+		p.warnf("OTEL_EXPORTER_OTLP_PROTOCOL %q is not supported, using http/json", protocol)
+	}
+
+	headers := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")
+	if headers == "" {
+		headers = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+	}
+	for _, pair := range strings.Split(headers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		decoded, err := url.QueryUnescape(strings.TrimSpace(value))
+		if !ok || strings.TrimSpace(name) == "" || err != nil {
+			p.warnf("ignoring malformed OTLP header %q", strings.TrimSpace(name))
+			continue
+		}
+		if cfg.OTLPHeaders == nil {
+			cfg.OTLPHeaders = make(map[string]string)
+		}
+		cfg.OTLPHeaders[strings.TrimSpace(name)] = decoded
+	}
+	return cfg
+}
+
+// traceAPIOption registers a middleware recording a span for every part uploaded or downloaded
+// on behalf of a traced phase. Requests made outside a span are not recorded.
+func traceAPIOption(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3CacheTraceParts",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+			middleware.InitializeOutput, middleware.Metadata, error,
+		) {
+			if spanFromContext(ctx) == nil {
+				return next.HandleInitialize(ctx, in)
+			}
+
+			var span *Span
+			switch params := in.Parameters.(type) {
+			case *s3.UploadPartInput:
+				ctx, span = StartSpan(ctx, SpanUploadPart, "part_number", aws.ToInt32(params.PartNumber))
+			case *s3.PutObjectInput:
+				// Streams smaller than one part are sent as a single PutObject
+				ctx, span = StartSpan(ctx, SpanUploadPart, "part_number", int32(1))
+			case *s3.GetObjectInput:
+				ctx, span = StartSpan(ctx, SpanDownloadPart, "range", aws.ToString(params.Range))
+			default:
+				return next.HandleInitialize(ctx, in)
+			}
+
+			out, md, err := next.HandleInitialize(ctx, in)
+			span.SetError(err)
+			// The part body is read after the call returns, so a download part ends when it is closed
+			if get, ok := out.Result.(*s3.GetObjectOutput); ok && err == nil && get.Body != nil {
+				span.Set("bytes", aws.ToInt64(get.ContentLength))
+				get.Body = &spanBody{ReadCloser: get.Body, span: span}
+				return out, md, err
+			}
+			span.End()
+			return out, md, err
+		}), middleware.After)
+}
+
+// spanBody ends its span once the response body is closed.
+type spanBody struct {
+	io.ReadCloser
+	span *Span
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// randomHex returns n random bytes as hex, used for trace and span ids.
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf) // only fails if the OS entropy source is unavailable
+	return hex.EncodeToString(buf)
+}
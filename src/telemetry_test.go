@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestTracerSpans(t *testing.T) {
+	tr := newTracer()
+	ctx, root := tr.Start(context.Background(), "get", "bucket", "b")
+	childCtx, child := StartSpan(ctx, SpanExtract)
+	recordPhase(childCtx, SpanDecompress, child.StartTime, 40*time.Millisecond)
+	child.SetError(errors.New("boom"))
+	child.End()
+	child.End() // ending twice must not record the span twice
+	root.End()
+
+	r := tr.report("get")
+	if len(r.Spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(r.Spans))
+	}
+	byName := make(map[string]SpanRecord)
+	for _, s := range r.Spans {
+		byName[s.Name] = s
+	}
+	if byName["get"].ParentID != "" || byName["get"].Attributes["bucket"] != "b" {
+		t.Errorf("unexpected root span %+v", byName["get"])
+	}
+	if byName[SpanExtract].ParentID != root.ID || byName[SpanExtract].Error != "boom" {
+		t.Errorf("unexpected extract span %+v", byName[SpanExtract])
+	}
+	decompress := byName[SpanDecompress]
+	if decompress.ParentID != child.ID || !decompress.Aggregate || decompress.DurationMS != 40 {
+		t.Errorf("unexpected decompress span %+v", decompress)
+	}
+	if r.Phases[SpanDecompress].Count != 1 || r.Phases[SpanDecompress].TotalMS != 40 {
+		t.Errorf("unexpected decompress totals %+v", r.Phases[SpanDecompress])
+	}
+	if r.TraceID != tr.traceID || len(r.TraceID) != 32 {
+		t.Errorf("unexpected trace id %q", r.TraceID)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	tr := newTracer()
+	ctx, root := tr.Start(context.Background(), "put")
+	for range 2 {
+		_, part := StartSpan(ctx, SpanUploadPart)
+		part.End()
+	}
+	root.End()
+
+	path := filepath.Join(t.TempDir(), "reports", "timings.json")
+	if err := tr.WriteReport(path, PutAction); err != nil {
+		t.Fatalf("WriteReport failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var r TraceReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if r.Action != PutAction || len(r.Spans) != 3 || r.Phases[SpanUploadPart].Count != 2 {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
+
+func TestExportOTLP(t *testing.T) {
+	var got otlpTraces
+	var contentType, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType, auth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	tr := newTracer()
+	ctx, root := tr.Start(context.Background(), "get")
+	_, part := StartSpan(ctx, SpanDownloadPart, "bytes", int64(5<<20))
+	part.SetError(errors.New("timeout"))
+	part.End()
+	root.End()
+
+	cfg := TelemetryConfig{
+		OTLPEndpoint: server.URL + "/v1/traces",
+		OTLPHeaders:  map[string]string{"Authorization": "Bearer token"},
+		ServiceName:  "ci-cache",
+	}
+	if err := tr.exportOTLP(context.Background(), cfg); err != nil {
+		t.Fatalf("exportOTLP failed: %v", err)
+	}
+	if contentType != "application/json" || auth != "Bearer token" {
+		t.Errorf("unexpected headers: content-type %q, authorization %q", contentType, auth)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected payload shape %+v", got)
+	}
+	resource := got.ResourceSpans[0].Resource.Attributes
+	if resource[0].Key != "service.name" || *resource[0].Value.StringValue != "ci-cache" {
+		t.Errorf("unexpected resource attributes %+v", resource)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	partSpan := spans[1]
+	if partSpan.TraceID != tr.traceID || partSpan.ParentSpanID != spans[0].SpanID {
+		t.Errorf("part span not linked to root: %+v", partSpan)
+	}
+	if partSpan.Status == nil || partSpan.Status.Code != otlpStatusError {
+		t.Errorf("expected error status, got %+v", partSpan.Status)
+	}
+	if a := partSpan.Attributes; len(a) != 1 || a[0].Value.IntValue == nil || *a[0].Value.IntValue != "5242880" {
+		t.Errorf("expected integer bytes attribute, got %+v", a)
+	}
+}
+
+func TestExportOTLPRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := newTracer().exportOTLP(context.Background(), TelemetryConfig{OTLPEndpoint: server.URL})
+	if err == nil || !strings.Contains(err.Error(), "bad payload") {
+		t.Errorf("expected collector error, got %v", err)
+	}
+}
+
+func TestParseTelemetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		endpoint string
+		headers  map[string]string
+		errors   int
+		warnings int
+	}{
+		{name: "disabled", env: map[string]string{}},
+		{
+			name:     "base endpoint",
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318/"},
+			endpoint: "http://localhost:4318/v1/traces",
+		},
+		{
+			name: "traces endpoint",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://ignored:4318",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "https://collector/custom",
+			},
+			endpoint: "https://collector/custom",
+		},
+		{
+			name: "headers",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+				"OTEL_EXPORTER_OTLP_HEADERS":  "Authorization=Bearer%20abc, x-tenant = ci,broken",
+			},
+			endpoint: "http://localhost:4318/v1/traces",
+			headers:  map[string]string{"Authorization": "Bearer abc", "x-tenant": "ci"},
+			warnings: 1,
+		},
+		{
+			name:     "unsupported protocol",
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"},
+			endpoint: "http://localhost:4318/v1/traces",
+			warnings: 1,
+		},
+		{
+			name:   "invalid endpoint",
+			env:    map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "localhost:4318"},
+			errors: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{"REPORT_FILE", "OTEL_SERVICE_NAME", "OTEL_EXPORTER_OTLP_ENDPOINT",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS",
+				"OTEL_EXPORTER_OTLP_TRACES_HEADERS", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+				t.Setenv(k, tc.env[k])
+			}
+
+			p := &configProblems{}
+			cfg := ParseTelemetry(p)
+			if cfg.OTLPEndpoint != tc.endpoint {
+				t.Errorf("expected endpoint %q, got %q", tc.endpoint, cfg.OTLPEndpoint)
+			}
+			if cfg.ServiceName != "s3-cache" {
+				t.Errorf("expected default service name, got %q", cfg.ServiceName)
+			}
+			for k, v := range tc.headers {
+				if cfg.OTLPHeaders[k] != v {
+					t.Errorf("expected header %s=%q, got %q", k, v, cfg.OTLPHeaders[k])
+				}
+			}
+			if len(p.errors) != tc.errors || len(p.warnings) != tc.warnings {
+				t.Errorf("expected %d errors and %d warnings, got %v and %v", tc.errors, tc.warnings, p.errors, p.warnings)
+			}
+		})
+	}
+}
+
+func TestTraceAPIOption(t *testing.T) {
+	stack := middleware.NewStack("test", smithyhttp.NewStackRequest)
+	if err := traceAPIOption(stack); err != nil {
+		t.Fatalf("failed to add middleware: %v", err)
+	}
+	// Stands in for the operation deserializer, which turns the response into the output
+	stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("result",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+			middleware.DeserializeOutput, middleware.Metadata, error,
+		) {
+			out, md, err := next.HandleDeserialize(ctx, in)
+			out.Result = out.RawResponse
+			return out, md, err
+		}), middleware.After)
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(
+		func(ctx context.Context, in interface{}) (interface{}, middleware.Metadata, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("data")), ContentLength: aws.Int64(4)},
+				middleware.Metadata{}, nil
+		}), stack)
+
+	tr := newTracer()
+	ctx, root := tr.Start(context.Background(), SpanDownload)
+	out, _, err := handler.Handle(ctx, &s3.GetObjectInput{Range: aws.String("bytes=0-3")})
+	if err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if len(tr.finished()) != 0 {
+		t.Fatal("download part must stay open until its body is closed")
+	}
+	body := out.(*s3.GetObjectOutput).Body
+	io.Copy(io.Discard, body)
+	body.Close()
+	root.End()
+
+	spans := tr.finished()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	part := spans[1]
+	if part.Name != SpanDownloadPart || part.ParentID != root.ID || part.Attrs["range"] != "bytes=0-3" || part.Attrs["bytes"] != int64(4) {
+		t.Errorf("unexpected part span %+v", part)
+	}
+
+	// Requests outside a traced phase are not recorded
+	before := len(tracer.finished())
+	if _, _, err := handler.Handle(context.Background(), &s3.GetObjectInput{}); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if len(tracer.finished()) != before {
+		t.Error("untraced request was recorded")
+	}
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"strings"
+	"time"
+)
+
 const (
 	// PutAction - Put artifacts
 	PutAction = "put"
@@ -10,6 +15,15 @@ const (
 	// GetAction - Get artifacts
 	GetAction = "get"
 
+	// ListAction - List caches under a prefix
+	ListAction = "list"
+
+	// InspectAction - Show a cache's metadata and file listing
+	InspectAction = "inspect"
+
+	// PruneAction - Remove caches under a prefix according to a policy
+	PruneAction = "prune"
+
 	// ErrCodeNotFound - s3 Not found error code
 	ErrCodeNotFound = "NotFound"
 
@@ -21,25 +35,119 @@ const (
 type (
 	// Action - Input params
 	Action struct {
-		Action     string
-		Bucket     string
-		S3Class    string
-		DefaultKey string
-		Key        string
-		Artifacts  []string
+		Action      string
+		Bucket      string
+		S3Class     string
+		DefaultKey  string
+		Key         string
+		RestoreKeys []string // prefixes searched in order when Key is not found, defaults to DefaultKey
+		Prefix      string   // key prefix for list, defaults to DefaultKey
+		KeyPrefix   string   // namespace prepended to every key, e.g. "owner/repo/"
+		Artifacts   []string
 
 		// Compression settings
 		Compression      string // "zstd" or "none"
 		CompressionLevel int    // zstd level (1-19), 0 = default
 
+		// Path mapping between the archive and the file system
+		PathRewrites PathRewrites // prefix rewrites applied to archived names on put, reversed on get
+		RestoreRoot  string       // directory get extracts into, "" = current directory
+
+		CrossOSArchive bool // share caches between Linux, macOS and Windows runners
+
+		// Byte-identical archives for identical inputs, with timestamps clamped to SourceDateEpoch
+		Reproducible    bool
+		SourceDateEpoch time.Time // zero = Unix epoch
+
+		// Client-side encryption keys, nil = no encryption
+		Keyring *Keyring
+		// Restore caches that are not encrypted even though a keyring is configured
+		AllowUnencrypted bool
+
+		// S3 server-side encryption
+		SSE ServerSideEncryption
+
+		// Cache signing, nil = caches are not signed / signatures are not checked
+		Signer      *Signer
+		TrustedKeys *TrustedKeys
+
+		ArchiveConcurrency int // number of files read in parallel while archiving
+		ExtractConcurrency int // number of files written in parallel while extracting
+
 		// S3 transfer settings
 		UploadConcurrency   int   // number of parallel upload parts
 		DownloadConcurrency int   // number of parallel download parts
 		UploadPartSize      int64 // part size in bytes for uploads, 0 = auto
 		DownloadPartSize    int64 // part size in bytes for downloads
+
+		// Pruning settings
+		Prune  PrunePolicy
+		DryRun bool // report what would change without touching the bucket
+
+		TrackAccess bool // record restores so pruning can evict by last use
+
+		// Get behaviour
+		LookupOnly      bool // report whether a cache exists without downloading it
+		FailOnCacheMiss bool // exit non-zero when no usable cache is found
+
+		// Key namespacing by git ref
+		Scope Scope
+
+		// Named caches from CONFIG_FILE, processed instead of Key and Artifacts
+		Caches []NamedCache
+
+		ProgressInterval time.Duration // time between progress events of long transfers, 0 = default
+
+		// Phase timing and metrics export
+		Telemetry TelemetryConfig
+		Metrics   MetricsConfig
+	}
+
+	// NamedCache - One cache entry of a config file, resolved into its own Action
+	NamedCache struct {
+		Name   string
+		Action Action
+	}
+
+	// CacheObject - A cache archive stored in the bucket
+	CacheObject struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+		LastAccessed time.Time // last restore, zero if never restored
+		StorageClass string
+		Compression  string // derived from the key extension
 	}
 )
 
+// storeKey returns the object key put and delete operate on, inside the scope's write namespace.
+func (a Action) storeKey() string {
+	return a.scopedKey(a.Scope.writeNamespace(), a.Key)
+}
+
+// scopedKey places an already prefixed key inside a scope namespace. The key prefix stays
+// outermost so all of a repository's caches, across every branch, share one listing prefix.
+func (a Action) scopedKey(namespace string, key string) string {
+	return a.KeyPrefix + namespacedKey(namespace, strings.TrimPrefix(key, a.KeyPrefix))
+}
+
+// ArchiveConfig returns the archive format configuration derived from this Action.
+func (a Action) ArchiveConfig() ArchiveConfig {
+	return ArchiveConfig{
+		Compression:        a.Compression,
+		CompressionLevel:   a.CompressionLevel,
+		Keyring:            a.Keyring,
+		AllowUnencrypted:   a.AllowUnencrypted,
+		Rewrites:           a.PathRewrites,
+		RestoreRoot:        a.RestoreRoot,
+		CrossOS:            a.CrossOSArchive,
+		ReadConcurrency:    a.ArchiveConcurrency,
+		ExtractConcurrency: a.ExtractConcurrency,
+		Reproducible:       a.Reproducible,
+		SourceDateEpoch:    a.SourceDateEpoch,
+	}
+}
+
 // TransferConfig returns the S3 transfer configuration derived from this Action.
 func (a Action) TransferConfig() TransferConfig {
 	return TransferConfig{
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// Accepted zstd compression levels, as in the zstd command line tool
+	minCompressionLevel = 1
+	maxCompressionLevel = 19
+
+	// maxKeyLength is the S3 limit on object key length in bytes
+	maxKeyLength = 1024
+)
+
+// configProblems collects configuration problems so they can be reported together.
+// Errors always fail; warnings describe values that were replaced with a safe default.
+type configProblems struct {
+	errors   []string
+	warnings []string
+}
+
+func (p *configProblems) errorf(format string, args ...any) {
+	p.errors = append(p.errors, fmt.Sprintf(format, args...))
+}
+
+func (p *configProblems) warnf(format string, args ...any) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, args...))
+}
+
+// check records err, if any, as an error.
+func (p *configProblems) check(err error) {
+	if err != nil {
+		p.errors = append(p.errors, err.Error())
+	}
+}
+
+// err returns every error as a single error. Warnings are logged, or treated as errors when strict.
+func (p *configProblems) err(strict bool) error {
+	problems := p.errors
+	if strict {
+		problems = append(problems, p.warnings...)
+	} else {
+		for _, w := range p.warnings {
+			slog.Warn("configuration warning", "problem", w)
+		}
+	}
+
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("invalid configuration: %s", problems[0])
+	default:
+		return fmt.Errorf("invalid configuration, %d problems: %s", len(problems), strings.Join(problems, "; "))
+	}
+}
+
+// validateAction checks the parsed action for missing required settings and out-of-range values.
+func validateAction(a Action, p *configProblems) {
+	validActions := []string{PutAction, DeleteAction, GetAction, ListAction, InspectAction, PruneAction}
+	switch {
+	case a.Action == "":
+		p.errorf("ACTION is required, valid options: %s", strings.Join(validActions, ", "))
+	case !slices.Contains(validActions, a.Action):
+		p.errorf("invalid action %q, valid options: %s", a.Action, strings.Join(validActions, ", "))
+	}
+	if a.Bucket == "" {
+		p.errorf("BUCKET is required")
+	}
+	if a.Action == PruneAction && !a.Prune.enabled() {
+		p.errorf("no prune policy configured, set at least one of PRUNE_MAX_AGE, PRUNE_MAX_TOTAL_SIZE or PRUNE_KEEP_NEWEST")
+	}
+
+	if len(a.Caches) == 0 {
+		validateCache(a, p)
+	} else {
+		validateCaches(a, p)
+	}
+
+	if a.S3Class != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(a.S3Class)) {
+		p.errorf("invalid S3_CLASS %q, valid options: %s", a.S3Class, strings.Join(storageClassNames(), ", "))
+	}
+
+	checkConcurrency := func(name string, n int) {
+		switch {
+		case n < 0:
+			p.warnf("%s %d is negative, using default", name, n)
+		case n > maxConcurrency:
+			p.warnf("%s %d above maximum, using %d", name, n, maxConcurrency)
+		}
+	}
+	checkConcurrency("ARCHIVE_CONCURRENCY", a.ArchiveConcurrency)
+	checkConcurrency("EXTRACT_CONCURRENCY", a.ExtractConcurrency)
+	checkConcurrency("UPLOAD_CONCURRENCY", a.UploadConcurrency)
+	checkConcurrency("DOWNLOAD_CONCURRENCY", a.DownloadConcurrency)
+
+	// Only uploads are bound by the S3 multipart limits
+	if size := a.UploadPartSize; size < 0 || size > 0 && (size < minPartSize || size > maxPartSize) {
+		p.warnf("UPLOAD_PART_SIZE %s out of range %s-%s, clamping",
+			getReadableBytes(size), getReadableBytes(minPartSize), getReadableBytes(maxPartSize))
+	}
+	if a.DownloadPartSize < 0 {
+		p.warnf("DOWNLOAD_PART_SIZE must not be negative, using default")
+	}
+	if a.Prune.KeepNewest < 0 {
+		p.errorf("PRUNE_KEEP_NEWEST must not be negative")
+	}
+}
+
+// validateCache checks the settings describing a single cache: its keys, artifacts and compression.
+func validateCache(a Action, p *configProblems) {
+	if a.Compression != CompressionZstd && a.Compression != CompressionNone {
+		p.errorf("invalid compression mode %q, valid options: %s, %s",
+			a.Compression, CompressionZstd, CompressionNone)
+	}
+
+	// Key is always suffixed with the archive extension, so an empty KEY leaves only that
+	keySet := a.Key != a.KeyPrefix+keyExtension(a.Compression)
+	switch a.Action {
+	case PutAction, GetAction, DeleteAction, InspectAction:
+		if !keySet {
+			p.errorf("KEY is required for %s", a.Action)
+		}
+	}
+	if a.Action == PutAction && len(strings.TrimSpace(strings.Join(a.Artifacts, ""))) == 0 {
+		p.errorf("ARTIFACTS is required for %s", a.Action)
+	}
+
+	if keySet {
+		if err := validateKey(a.Key); err != nil {
+			p.errorf("KEY: %v", err)
+		}
+	}
+	for _, rk := range a.RestoreKeys {
+		if rk == a.KeyPrefix {
+			continue
+		}
+		// Restore keys are prefixes and may end in a separator
+		if err := validateKey(strings.TrimSuffix(rk, "/")); err != nil {
+			p.errorf("DEFAULT_KEY: %v", err)
+		}
+	}
+
+	if a.CompressionLevel != 0 {
+		if a.Compression == CompressionNone {
+			p.warnf("COMPRESSION_LEVEL is ignored when COMPRESSION is %q", CompressionNone)
+		} else if a.CompressionLevel < minCompressionLevel || a.CompressionLevel > maxCompressionLevel {
+			p.errorf("COMPRESSION_LEVEL %d out of range %d-%d", a.CompressionLevel, minCompressionLevel, maxCompressionLevel)
+		}
+	}
+}
+
+// validateCaches checks every config file entry, labelling each problem with the entry name.
+func validateCaches(a Action, p *configProblems) {
+	switch a.Action {
+	case PutAction, GetAction, DeleteAction:
+	default:
+		p.errorf("CONFIG_FILE is only supported for %s, %s and %s", PutAction, GetAction, DeleteAction)
+	}
+
+	seen := make(map[string]bool)
+	for i, c := range a.Caches {
+		label := c.Name
+		switch {
+		case c.Name == "":
+			label = fmt.Sprintf("#%d", i+1)
+			p.errorf("cache %s: name is required", label)
+		case seen[c.Name]:
+			p.errorf("cache %q: duplicate name", c.Name)
+		}
+		seen[c.Name] = true
+
+		entry := &configProblems{}
+		validateCache(c.Action, entry)
+		for _, e := range entry.errors {
+			p.errorf("cache %s: %s", label, e)
+		}
+		for _, w := range entry.warnings {
+			p.warnf("cache %s: %s", label, w)
+		}
+	}
+}
+
+// validateKey enforces the characters cache keys may contain. Besides the S3 length limit,
+// keys must be valid UTF-8 without control characters, and must not contain empty, "." or ".."
+// path segments, which S3 consoles and path-style tooling treat inconsistently.
+func validateKey(key string) error {
+	if len(key) > maxKeyLength {
+		return fmt.Errorf("key is %d bytes, longer than the S3 limit of %d", len(key), maxKeyLength)
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("key %q is not valid UTF-8", key)
+	}
+	if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return fmt.Errorf("key %q contains control characters", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("key %q contains an empty, \".\" or \"..\" path segment", key)
+		}
+	}
+	return nil
+}
+
+// storageClassNames returns the storage classes accepted by S3_CLASS.
+func storageClassNames() []string {
+	var names []string
+	for _, c := range types.StorageClass("").Values() {
+		names = append(names, string(c))
+	}
+	return names
+}
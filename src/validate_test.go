@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"linux-yarn-abc.tar.zst", true},
+		{"acme/widgets/heads/feature/x/linux-yarn.tar.zst", true},
+		{"/linux-yarn.tar.zst", false},
+		{"acme//linux-yarn.tar.zst", false},
+		{"acme/../linux-yarn.tar.zst", false},
+		{"linux\tyarn.tar.zst", false},
+		{"linux-\xff.tar.zst", false},
+		{strings.Repeat("k", maxKeyLength+1), false},
+	}
+	for _, tt := range tests {
+		err := validateKey(tt.key)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateKey(%q) error = %v, want valid = %v", tt.key, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateAction(t *testing.T) {
+	valid := Action{
+		Action:      PutAction,
+		Bucket:      "b",
+		Key:         "k.tar.zst",
+		Artifacts:   []string{"node_modules"},
+		Compression: CompressionZstd,
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		p := &configProblems{}
+		validateAction(valid, p)
+		if len(p.errors) != 0 || len(p.warnings) != 0 {
+			t.Errorf("unexpected problems: errors %v, warnings %v", p.errors, p.warnings)
+		}
+	})
+
+	t.Run("aggregates_errors", func(t *testing.T) {
+		a := valid
+		a.Bucket = ""
+		a.Key = keyExtension(a.Compression)
+		a.Artifacts = []string{""}
+		a.S3Class = "CHEAP"
+		a.CompressionLevel = 22
+
+		p := &configProblems{}
+		validateAction(a, p)
+		err := p.err(false)
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		for _, want := range []string{"5 problems", "BUCKET", "KEY", "ARTIFACTS", "S3_CLASS", "COMPRESSION_LEVEL"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not mention %q", err, want)
+			}
+		}
+	})
+
+	t.Run("required_per_action", func(t *testing.T) {
+		list := Action{Action: ListAction, Bucket: "b", Key: keyExtension(CompressionZstd), Compression: CompressionZstd}
+		p := &configProblems{}
+		validateAction(list, p)
+		if len(p.errors) != 0 {
+			t.Errorf("list should not require KEY or ARTIFACTS: %v", p.errors)
+		}
+
+		prune := list
+		prune.Action = PruneAction
+		p = &configProblems{}
+		validateAction(prune, p)
+		if len(p.errors) != 1 {
+			t.Errorf("prune without a policy should fail, got %v", p.errors)
+		}
+
+		p = &configProblems{}
+		validateAction(Action{Action: "upload", Bucket: "b"}, p)
+		if len(p.errors) == 0 || !strings.Contains(p.errors[0], "invalid action") {
+			t.Errorf("expected invalid action error, got %v", p.errors)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		a := valid
+		a.UploadPartSize = 1024
+		a.DownloadConcurrency = 500
+
+		p := &configProblems{}
+		validateAction(a, p)
+		if len(p.warnings) != 2 {
+			t.Fatalf("expected 2 warnings, got %v", p.warnings)
+		}
+		if err := p.err(false); err != nil {
+			t.Errorf("warnings should not fail without strict: %v", err)
+		}
+		if err := p.err(true); err == nil {
+			t.Error("warnings should fail with strict")
+		}
+	})
+}
+
+func TestParseActionStrict(t *testing.T) {
+	for _, k := range []string{"ACTION", "BUCKET", "KEY", "KEY_PREFIX", "ARTIFACTS", "UPLOAD_CONCURRENCY", "STRICT"} {
+		t.Setenv(k, "")
+	}
+	os.Setenv("ACTION", "get")
+	os.Setenv("BUCKET", "b")
+	os.Setenv("KEY", "k")
+	os.Setenv("UPLOAD_CONCURRENCY", "lots")
+
+	action, err := ParseAction()
+	if err != nil {
+		t.Fatalf("ParseAction failed: %v", err)
+	}
+	if action.UploadConcurrency != 0 {
+		t.Errorf("UploadConcurrency = %d, want default", action.UploadConcurrency)
+	}
+
+	os.Setenv("STRICT", "true")
+	if _, err := ParseAction(); err == nil || !strings.Contains(err.Error(), "UPLOAD_CONCURRENCY") {
+		t.Errorf("expected strict error mentioning UPLOAD_CONCURRENCY, got %v", err)
+	}
+}